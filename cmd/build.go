@@ -5,14 +5,19 @@
 package cmd
 
 import (
+	"io"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/olekukonko/tablewriter"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
 	"github.com/elastic/elastic-package/internal/builder"
 	"github.com/elastic/elastic-package/internal/cobraext"
 	"github.com/elastic/elastic-package/internal/docs"
+	"github.com/elastic/elastic-package/internal/fields"
 	"github.com/elastic/elastic-package/internal/files"
 	"github.com/elastic/elastic-package/internal/logger"
 	"github.com/elastic/elastic-package/internal/packages"
@@ -38,6 +43,7 @@ func setupBuildCommand() *cobraext.Command {
 	cmd.Flags().Bool(cobraext.BuildZipFlagName, true, cobraext.BuildZipFlagDescription)
 	cmd.Flags().Bool(cobraext.SignPackageFlagName, false, cobraext.SignPackageFlagDescription)
 	cmd.Flags().Bool(cobraext.BuildSkipValidationFlagName, false, cobraext.BuildSkipValidationFlagDescription)
+	cmd.Flags().String(cobraext.BuildSchemaSnapshotFlagName, "", cobraext.BuildSchemaSnapshotFlagDescription)
 	return cobraext.NewCommand(cmd, cobraext.ContextPackage)
 }
 
@@ -47,6 +53,7 @@ func buildCommandAction(cmd *cobra.Command, args []string) error {
 	createZip, _ := cmd.Flags().GetBool(cobraext.BuildZipFlagName)
 	signPackage, _ := cmd.Flags().GetBool(cobraext.SignPackageFlagName)
 	skipValidation, _ := cmd.Flags().GetBool(cobraext.BuildSkipValidationFlagName)
+	schemaSnapshot, _ := cmd.Flags().GetString(cobraext.BuildSchemaSnapshotFlagName)
 
 	if signPackage && !createZip {
 		return errors.New("can't sign the unzipped package, please use also the --zip switch")
@@ -80,17 +87,46 @@ func buildCommandAction(cmd *cobra.Command, args []string) error {
 		cmd.Printf("%s file rendered: %s\n", splitTarget[len(splitTarget)-1], target)
 	}
 
+	importedFields := make(map[string]int)
 	target, err := builder.BuildPackage(builder.BuildOptions{
 		PackageRoot:    packageRoot,
 		CreateZip:      createZip,
 		SignPackage:    signPackage,
 		SkipValidation: skipValidation,
+		ImportedFields: importedFields,
+		SchemaSnapshot: schemaSnapshot,
 	})
 	if err != nil {
 		return errors.Wrap(err, "building package failed")
 	}
+	if len(importedFields) > 0 {
+		cmd.Println("Imported fields:")
+		printImportedFields(cmd.OutOrStdout(), importedFields)
+	}
 	cmd.Printf("Package built: %s\n", target)
+	logger.Debugf("Schema cache statistics: %s", fields.CurrentSchemaCacheStats())
 
 	cmd.Println("Done")
 	return nil
 }
+
+// printImportedFields prints a table with the number of fields imported in each data stream.
+func printImportedFields(w io.Writer, importedFields map[string]int) {
+	var dataStreams []string
+	for dataStream := range importedFields {
+		dataStreams = append(dataStreams, dataStream)
+	}
+	sort.Strings(dataStreams)
+
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Data stream", "Imported fields"})
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	for _, dataStream := range dataStreams {
+		name := dataStream
+		if name == "" {
+			name = "(package)"
+		}
+		table.Append([]string{name, strconv.Itoa(importedFields[dataStream])})
+	}
+	table.Render()
+}
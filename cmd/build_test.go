@@ -0,0 +1,32 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintImportedFields(t *testing.T) {
+	var buf bytes.Buffer
+	printImportedFields(&buf, map[string]int{
+		"access": 12,
+		"":       3,
+		"error":  5,
+	})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if assert.Len(t, lines, 7) {
+		assert.Contains(t, lines[1], "DATA STREAM")
+		assert.Contains(t, lines[3], "(package)")
+		assert.Contains(t, lines[3], "3")
+		assert.Contains(t, lines[4], "access")
+		assert.Contains(t, lines[4], "12")
+		assert.Contains(t, lines[5], "error")
+	}
+}
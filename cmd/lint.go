@@ -5,19 +5,26 @@
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
 	"github.com/elastic/package-spec/v2/code/go/pkg/validator"
 
+	"github.com/elastic/elastic-package/internal/builder"
 	"github.com/elastic/elastic-package/internal/cobraext"
 	"github.com/elastic/elastic-package/internal/docs"
+	"github.com/elastic/elastic-package/internal/fields"
+	"github.com/elastic/elastic-package/internal/logger"
 	"github.com/elastic/elastic-package/internal/packages"
 )
 
 const lintLongDescription = `Use this command to validate the contents of a package using the package specification (see: https://github.com/elastic/package-spec).
 
-The command ensures that the package is aligned with the package spec and the README file is up-to-date with its template (if present).`
+The command ensures that the package is aligned with the package spec and the README file is up-to-date with its template (if present).
+
+If the package has been built, the command also checks that the external fields of the built package are up-to-date with its dependencies.`
 
 func setupLintCommand() *cobraext.Command {
 	cmd := &cobra.Command{
@@ -28,6 +35,7 @@ func setupLintCommand() *cobraext.Command {
 			err := cobraext.ComposeCommandActions(cmd, args,
 				lintCommandAction,
 				validateSourceCommandAction,
+				validateExternalFieldsCommandAction,
 			)
 			if err != nil {
 				return err
@@ -73,3 +81,37 @@ func validateSourceCommandAction(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+func validateExternalFieldsCommandAction(cmd *cobra.Command, args []string) error {
+	packageRootPath, found, err := packages.FindPackageRoot()
+	if !found {
+		return errors.New("package root not found")
+	}
+	if err != nil {
+		return errors.Wrap(err, "locating package root failed")
+	}
+	builtPackageDir, found, err := builder.FindBuiltPackageDirectory(packageRootPath)
+	if err != nil {
+		return errors.Wrap(err, "locating built package failed")
+	}
+	if !found {
+		logger.Debugf("Package hasn't been built, external fields are not checked")
+		return nil
+	}
+
+	outdated, err := builder.AreExternalFieldsUpToDate(packageRootPath, builtPackageDir)
+	if errors.Is(err, fields.ErrSchemaNotCached) {
+		logger.Warnf("External fields are not checked, schemas of the dependencies are not cached (%v). Build the package to download them.", err)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "checking external fields are up-to-date failed")
+	}
+	for _, f := range outdated {
+		cmd.Printf("%s has outdated external fields. Rebuild the package with 'elastic-package build'\n%s", f.Path, f.Diff)
+	}
+	if len(outdated) > 0 {
+		return fmt.Errorf("external fields of the built package are outdated in %d fields files", len(outdated))
+	}
+	return nil
+}
@@ -5,91 +5,263 @@
 package builder
 
 import (
+	"bytes"
+	"context"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
 	"gopkg.in/yaml.v3"
 
 	"github.com/elastic/elastic-package/internal/common"
 	"github.com/elastic/elastic-package/internal/fields"
 	"github.com/elastic/elastic-package/internal/logger"
+	"github.com/elastic/elastic-package/internal/packages"
 	"github.com/elastic/elastic-package/internal/packages/buildmanifest"
 )
 
-func resolveExternalFields(packageRoot, destinationDir string) error {
+// resolveExternalFields injects the external fields in the fields files of the built package. Type changes of
+// imported fields are reported by comparing them with previousFields, the contents of the fields files of the
+// previous build, by relative path. If importedFields is not nil, it receives the number of fields imported in
+// each data stream, keyed by data stream name, or by an empty string for the fields files of the package. If
+// schemaSnapshot is not empty, a snapshot of the schemas used is written to this path.
+func resolveExternalFields(packageRoot, destinationDir string, previousFields map[string][]byte, importedFields map[string]int, schemaSnapshot string) error {
+	fdm, ok, err := createFieldDependencyManager(packageRoot)
+	if err != nil || !ok {
+		return err
+	}
+
+	fieldsFiles, err := listFieldsFiles(destinationDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range fieldsFiles {
+		rel, _ := filepath.Rel(destinationDir, file)
+		dataStream := dataStreamName(rel)
+		provenance := make(map[string]string)
+		opts := []fields.InjectFieldsOption{fields.WithProvenance(provenance)}
+		if previous, found := previousFields[rel]; found {
+			var previousDefs []common.MapStr
+			err := yaml.Unmarshal(previous, &previousDefs)
+			if err != nil {
+				logger.Debugf("%s: can't parse fields file of previous build: %v", rel, err)
+			} else {
+				opts = append(opts, fields.WithPreviousFields(previousDefs))
+			}
+		}
+		output, injected, err := fields.BuildFields(file, fdm.ForDataStream(dataStream), opts...)
+		if err != nil {
+			return err
+		}
+		if importedFields != nil && len(provenance) > 0 {
+			importedFields[dataStream] += len(provenance)
+		}
+		if injected {
+			logger.Debugf("%s: source file has been changed", rel)
+
+			err = os.WriteFile(file, output, 0644)
+			if err != nil {
+				return err
+			}
+		} else {
+			logger.Debugf("%s: source file hasn't been changed", rel)
+		}
+	}
+
+	if schemaSnapshot != "" {
+		err = writeSchemaSnapshot(fdm, schemaSnapshot)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSchemaSnapshot writes a snapshot of the schemas used by the dependency manager to the given path.
+func writeSchemaSnapshot(fdm *fields.DependencyManager, path string) error {
+	var buf bytes.Buffer
+	err := fdm.ExportSchemas(&buf)
+	if err != nil {
+		return errors.Wrap(err, "can't export schemas")
+	}
+	err = os.WriteFile(path, buf.Bytes(), 0644)
+	if err != nil {
+		return errors.Wrapf(err, "can't write schema snapshot (path: %s)", path)
+	}
+	logger.Debugf("Schema snapshot written to %s", path)
+	return nil
+}
+
+// FieldsFile contains the path and expected content of a built fields file with outdated external fields.
+type FieldsFile struct {
+	// Path is the path of the fields file, relative to the package root.
+	Path string
+	// Expected is the content of the fields file, resolved from the package sources.
+	Expected []byte
+	// Diff is the unified diff between the built fields file and the expected one. Built fields files not
+	// found are compared as empty files.
+	Diff string
+}
+
+// AreExternalFieldsUpToDate function checks if the fields files of a built package are the same as the ones
+// resolved from the package sources with the current dependencies. Only fields files with external fields are
+// checked, and no file is modified. Only cached schemas are used, so it can be run offline, the returned error
+// wraps fields.ErrSchemaNotCached if a schema isn't cached. It returns the files that are out of date, or an
+// error if they can't be checked.
+func AreExternalFieldsUpToDate(packageRoot, builtPackageDir string) ([]FieldsFile, error) {
+	fdm, ok, err := createFieldDependencyManager(packageRoot, fields.WithCachedSchemasOnly())
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	fieldsFiles, err := listFieldsFiles(packageRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var outdated []FieldsFile
+	for _, file := range fieldsFiles {
+		rel, _ := filepath.Rel(packageRoot, file)
+		expected, injected, err := fields.BuildFields(file, fdm.ForDataStream(dataStreamName(rel)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't resolve external fields (path: %s)", rel)
+		}
+		if !injected {
+			continue
+		}
+
+		built, err := os.ReadFile(filepath.Join(builtPackageDir, rel))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, errors.Wrapf(err, "can't read built fields file (path: %s)", rel)
+		}
+		if bytes.Equal(built, expected) {
+			continue
+		}
+
+		var buf bytes.Buffer
+		err = difflib.WriteUnifiedDiff(&buf, difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(built)),
+			B:        difflib.SplitLines(string(expected)),
+			FromFile: "built",
+			ToFile:   "expected",
+			Context:  1,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't compare fields file (path: %s)", rel)
+		}
+		outdated = append(outdated, FieldsFile{
+			Path:     rel,
+			Expected: expected,
+			Diff:     buf.String(),
+		})
+	}
+	return outdated, nil
+}
+
+// createFieldDependencyManager creates the dependency manager for the dependencies of the package, with the given
+// options added to the ones defined in the build manifest. It returns false if the package doesn't have external
+// dependencies.
+func createFieldDependencyManager(packageRoot string, extraOpts ...fields.DependencyManagerOption) (*fields.DependencyManager, bool, error) {
 	bm, ok, err := buildmanifest.ReadBuildManifest(packageRoot)
 	if err != nil {
-		return errors.Wrap(err, "can't read build manifest")
+		return nil, false, errors.Wrap(err, "can't read build manifest")
 	}
 	if !ok {
 		logger.Debugf("Build manifest hasn't been defined for the package")
-		return nil
+		return nil, false, nil
 	}
 	if !bm.HasDependencies() {
 		logger.Debugf("Package doesn't have any external dependencies defined")
-		return nil
+		return nil, false, nil
 	}
 
 	logger.Debugf("Package has external dependencies defined")
-	fdm, err := fields.CreateFieldDependencyManager(bm.Dependencies)
+	m, err := packages.ReadPackageManifestFromPackageRoot(packageRoot)
 	if err != nil {
-		return errors.Wrap(err, "can't create field dependency manager")
+		return nil, false, errors.Wrapf(err, "reading package manifest failed (path: %s)", packageRoot)
 	}
-
-	dataStreamFieldsFiles, err := filepath.Glob(filepath.Join(destinationDir, "data_stream", "*", "fields", "*.yml"))
+	opts := []fields.DependencyManagerOption{
+		fields.WithTargetSpecVersion(m.SpecVersion),
+		fields.WithLazySchemaLoading(),
+		fields.WithSharedSchemas(),
+	}
+	importMode, err := fields.ParseImportMode(bm.Dependencies.ECS.ImportMode)
 	if err != nil {
-		return err
+		return nil, false, errors.Wrap(err, `invalid ECS dependency in build manifest "_dev/build/build.yml"`)
+	}
+	opts = append(opts, fields.WithImportMode(importMode))
+	if bm.Dependencies.ECS.StrictTypes {
+		opts = append(opts, fields.WithStrictTypes())
+	}
+	if bm.Dependencies.ECS.StrictDuplicates {
+		opts = append(opts, fields.WithStrictDuplicates())
+	}
+	if bm.Dependencies.ECS.StrictTypeChanges {
+		opts = append(opts, fields.WithStrictTypeChanges())
+	}
+	if bm.Dependencies.ECS.NameMetadata {
+		opts = append(opts, fields.WithECSNameMetadata())
+	}
+	if overrides := bm.Dependencies.ECS.LocalOverrides; overrides != "" {
+		opts = append(opts, fields.WithLocalOverrides(filepath.Join(packageRoot, overrides)))
+	}
+	if descriptionsPath := bm.Dependencies.ECS.Descriptions; descriptionsPath != "" {
+		descriptions, err := fields.ReadDescriptionOverlay(filepath.Join(packageRoot, descriptionsPath))
+		if err != nil {
+			return nil, false, err
+		}
+		opts = append(opts, fields.WithDescriptionOverlay(descriptions))
+	}
+	opts = append(opts, extraOpts...)
+	fdm, err := fields.CreateFieldDependencyManager(context.Background(), bm.Dependencies, opts...)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "can't create field dependency manager")
 	}
+	return fdm, true, nil
+}
 
-	packageFieldsFiles, err := filepath.Glob(filepath.Join(destinationDir, "fields", "*.yml"))
+// readFieldsFiles reads the fields files of a built package, by path relative to the package directory. It returns
+// no files if the package hasn't been built yet.
+func readFieldsFiles(packageDir string) (map[string][]byte, error) {
+	fieldsFiles, err := listFieldsFiles(packageDir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	var fieldsFiles = append(packageFieldsFiles, dataStreamFieldsFiles...)
+	contents := make(map[string][]byte, len(fieldsFiles))
 	for _, file := range fieldsFiles {
 		data, err := os.ReadFile(file)
 		if err != nil {
-			return err
-		}
-
-		rel, _ := filepath.Rel(destinationDir, file)
-		output, injected, err := injectFields(fdm, data)
-		if err != nil {
-			return err
-		} else if injected {
-			logger.Debugf("%s: source file has been changed", rel)
-
-			err = os.WriteFile(file, output, 0644)
-			if err != nil {
-				return err
-			}
-		} else {
-			logger.Debugf("%s: source file hasn't been changed", rel)
+			return nil, err
 		}
+		rel, _ := filepath.Rel(packageDir, file)
+		contents[rel] = data
 	}
-	return nil
+	return contents, nil
 }
 
-func injectFields(fdm *fields.DependencyManager, content []byte) ([]byte, bool, error) {
-	var f []common.MapStr
-	err := yaml.Unmarshal(content, &f)
+// listFieldsFiles returns the fields files of the package and its data streams.
+func listFieldsFiles(packageDir string) ([]string, error) {
+	dataStreamFieldsFiles, err := filepath.Glob(filepath.Join(packageDir, "data_stream", "*", "fields", "*.yml"))
 	if err != nil {
-		return nil, false, errors.Wrap(err, "can't unmarshal source file")
+		return nil, err
 	}
 
-	f, changed, err := fdm.InjectFields(f)
+	packageFieldsFiles, err := filepath.Glob(filepath.Join(packageDir, "fields", "*.yml"))
 	if err != nil {
-		return nil, false, errors.Wrap(err, "can't resolve fields")
-	}
-	if !changed {
-		return content, false, nil
+		return nil, err
 	}
+	return append(packageFieldsFiles, dataStreamFieldsFiles...), nil
+}
 
-	content, err = yaml.Marshal(&f)
-	if err != nil {
-		return nil, false, errors.Wrap(err, "can't marshal source file")
+// dataStreamName returns the name of the data stream a fields file belongs to, or an empty
+// string for package-level fields files. The path must be relative to the package root.
+func dataStreamName(fieldsFile string) string {
+	parts := strings.Split(filepath.ToSlash(fieldsFile), "/")
+	if len(parts) > 2 && parts[0] == "data_stream" {
+		return parts[1]
 	}
-	return content, true, nil
+	return ""
 }
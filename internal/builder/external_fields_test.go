@@ -0,0 +1,292 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package builder
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testECSSchema = `
+container:
+  name: container
+  type: group
+  fields:
+    container.id:
+      name: id
+      description: Unique container id.
+      type: keyword
+      flat_name: container.id
+      dashed_name: container-id
+`
+
+func TestAreExternalFieldsUpToDate(t *testing.T) {
+	const externalFieldsFile = "data_stream/test/fields/ecs.yml"
+
+	cases := []struct {
+		title string
+		// build resolves the fields files of the package in the built package.
+		build bool
+		// builtContent, if set, replaces the content of the built fields file with external fields.
+		builtContent string
+		outdated     []string
+	}{
+		{
+			title: "up-to-date",
+			build: true,
+		},
+		{
+			title:        "outdated",
+			build:        true,
+			builtContent: "- name: container.id\n  type: wildcard\n",
+			outdated:     []string{externalFieldsFile},
+		},
+		{
+			title:    "missing built file",
+			outdated: []string{externalFieldsFile},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			packageRoot := createTestPackage(t)
+			builtPackageDir := t.TempDir()
+			if c.build {
+				fieldsFiles, err := listFieldsFiles(packageRoot)
+				require.NoError(t, err)
+				for _, file := range fieldsFiles {
+					rel, _ := filepath.Rel(packageRoot, file)
+					content, err := os.ReadFile(file)
+					require.NoError(t, err)
+					writeTestFile(t, filepath.Join(builtPackageDir, rel), string(content))
+				}
+				err = resolveExternalFields(packageRoot, builtPackageDir, nil, nil, "")
+				require.NoError(t, err)
+			}
+			if c.builtContent != "" {
+				writeTestFile(t, filepath.Join(builtPackageDir, externalFieldsFile), c.builtContent)
+			}
+
+			outdated, err := AreExternalFieldsUpToDate(packageRoot, builtPackageDir)
+			require.NoError(t, err)
+
+			var paths []string
+			for _, f := range outdated {
+				paths = append(paths, filepath.ToSlash(f.Path))
+				assert.Contains(t, string(f.Expected), "type: keyword")
+				assert.Contains(t, f.Diff, "+++ expected")
+			}
+			assert.Equal(t, c.outdated, paths)
+		})
+	}
+}
+
+func TestResolveExternalFields(t *testing.T) {
+	const fieldsFile = "data_stream/test/fields/ecs.yml"
+	packageRoot := createTestPackage(t)
+	builtPackageDir := t.TempDir()
+	writeTestFile(t, filepath.Join(builtPackageDir, fieldsFile), "- name: container.id\n  external: ecs\n")
+
+	err := resolveExternalFields(packageRoot, builtPackageDir, nil, nil, "")
+	require.NoError(t, err)
+
+	built, err := os.ReadFile(filepath.Join(builtPackageDir, fieldsFile))
+	require.NoError(t, err)
+	assert.Equal(t, "- name: container.id\n  type: keyword\n  description: Unique container id.\n", string(built))
+}
+
+func TestResolveExternalFieldsImportMode(t *testing.T) {
+	const fieldsFile = "data_stream/test/fields/ecs.yml"
+	cases := []struct {
+		importMode string
+		expected   string
+		err        string
+	}{
+		{importMode: "default", expected: "- name: container.id\n  type: keyword\n  description: Unique container id.\n"},
+		{importMode: "build", expected: "- name: container.id\n  type: keyword\n"},
+		{importMode: "mappings", err: `invalid import mode "mappings"`},
+	}
+	for _, c := range cases {
+		t.Run(c.importMode, func(t *testing.T) {
+			packageRoot := createTestPackage(t, "import_mode: "+c.importMode)
+			builtPackageDir := t.TempDir()
+			writeTestFile(t, filepath.Join(builtPackageDir, fieldsFile), "- name: container.id\n  external: ecs\n")
+
+			err := resolveExternalFields(packageRoot, builtPackageDir, nil, nil, "")
+			if c.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.err)
+				return
+			}
+			require.NoError(t, err)
+
+			built, err := os.ReadFile(filepath.Join(builtPackageDir, fieldsFile))
+			require.NoError(t, err)
+			assert.Equal(t, c.expected, string(built))
+		})
+	}
+}
+
+func TestResolveExternalFieldsStrictTypes(t *testing.T) {
+	const fieldsFile = "data_stream/test/fields/ecs.yml"
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict_types: %t", strict), func(t *testing.T) {
+			packageRoot := createTestPackage(t, fmt.Sprintf("strict_types: %t", strict))
+			writeTestFile(t, filepath.Join(packageRoot, fieldsFile), "- name: container.id\n  external: ecs\n  type: long\n")
+
+			builtPackageDir := t.TempDir()
+			writeTestFile(t, filepath.Join(builtPackageDir, fieldsFile), "- name: container.id\n  external: ecs\n  type: long\n")
+			err := resolveExternalFields(packageRoot, builtPackageDir, nil, nil, "")
+			if strict {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), `field "container.id" declares type "long", incompatible with the imported type "keyword"`)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestResolveExternalFieldsStrictDuplicates(t *testing.T) {
+	const fieldsFile = "data_stream/test/fields/ecs.yml"
+	const fields = "- name: container.id\n  external: ecs\n- name: container.id\n  external: ecs\n"
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict_duplicates: %t", strict), func(t *testing.T) {
+			packageRoot := createTestPackage(t, fmt.Sprintf("strict_duplicates: %t", strict))
+			writeTestFile(t, filepath.Join(packageRoot, fieldsFile), fields)
+
+			builtPackageDir := t.TempDir()
+			writeTestFile(t, filepath.Join(builtPackageDir, fieldsFile), fields)
+			err := resolveExternalFields(packageRoot, builtPackageDir, nil, nil, "")
+			if strict {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "container.id")
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestResolveExternalFieldsStrictTypeChanges(t *testing.T) {
+	const fieldsFile = "data_stream/test/fields/ecs.yml"
+	previousFields := map[string][]byte{
+		filepath.FromSlash(fieldsFile): []byte("- name: container.id\n  type: wildcard\n"),
+	}
+	for _, strict := range []bool{false, true} {
+		t.Run(fmt.Sprintf("strict_type_changes: %t", strict), func(t *testing.T) {
+			packageRoot := createTestPackage(t, fmt.Sprintf("strict_type_changes: %t", strict))
+			builtPackageDir := t.TempDir()
+			writeTestFile(t, filepath.Join(builtPackageDir, fieldsFile), "- name: container.id\n  external: ecs\n")
+
+			err := resolveExternalFields(packageRoot, builtPackageDir, previousFields, nil, "")
+			if strict {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), `field "container.id" type changed wildcard → keyword since last build`)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestResolveExternalFieldsNameMetadata(t *testing.T) {
+	const fieldsFile = "data_stream/test/fields/ecs.yml"
+	cases := []struct {
+		title         string
+		formatVersion string
+		nameMetadata  bool
+		expected      string
+	}{
+		{
+			title:         "disabled",
+			formatVersion: "1.0.0",
+			expected:      "- name: container.id\n  type: keyword\n  description: Unique container id.\n",
+		},
+		{
+			title:         "enabled",
+			formatVersion: "1.0.0",
+			nameMetadata:  true,
+			expected:      "- name: container.id\n  type: keyword\n  dashed_name: container-id\n  description: Unique container id.\n  flat_name: container.id\n",
+		},
+		{
+			title:         "not supported by the spec version",
+			formatVersion: "2.0.0",
+			nameMetadata:  true,
+			expected:      "- name: container.id\n  type: keyword\n  description: Unique container id.\n",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			packageRoot := createTestPackage(t, fmt.Sprintf("name_metadata: %t", c.nameMetadata))
+			writeTestFile(t, filepath.Join(packageRoot, "manifest.yml"), "format_version: "+c.formatVersion+"\nname: test\nversion: 1.0.0\ntype: integration\n")
+			builtPackageDir := t.TempDir()
+			writeTestFile(t, filepath.Join(builtPackageDir, fieldsFile), "- name: container.id\n  external: ecs\n")
+
+			err := resolveExternalFields(packageRoot, builtPackageDir, nil, nil, "")
+			require.NoError(t, err)
+
+			built, err := os.ReadFile(filepath.Join(builtPackageDir, fieldsFile))
+			require.NoError(t, err)
+			assert.Equal(t, c.expected, string(built))
+		})
+	}
+}
+
+func TestResolveExternalFieldsDataStreamReference(t *testing.T) {
+	const fieldsFile = "data_stream/test/fields/ecs.yml"
+
+	packageRoot := createTestPackage(t, "data_streams:\n      test: git@v8.11.0")
+	builtPackageDir := t.TempDir()
+	writeTestFile(t, filepath.Join(builtPackageDir, fieldsFile), "- name: container.id\n  external: ecs\n")
+
+	err := resolveExternalFields(packageRoot, builtPackageDir, nil, nil, "")
+	require.NoError(t, err)
+
+	built, err := os.ReadFile(filepath.Join(builtPackageDir, fieldsFile))
+	require.NoError(t, err)
+	assert.Equal(t, "- name: container.id\n  type: wildcard\n  description: Unique container id.\n", string(built))
+}
+
+// createTestPackage creates a package with a fields file importing fields from ECS, and a fields file without
+// external fields. ECS schemas are served by a test server, the one of v8.11.0 defines container.id as wildcard,
+// the one of any other reference as keyword. The given settings are added to the ECS dependency.
+func createTestPackage(t *testing.T, ecsSettings ...string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ecs/v8.11.0/ecs_nested.yml":
+			fmt.Fprint(w, strings.ReplaceAll(testECSSchema, "type: keyword", "type: wildcard"))
+		default:
+			fmt.Fprint(w, testECSSchema)
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv("ELASTIC_PACKAGE_ECS_SCHEMA_URL", server.URL+"/ecs/%s/%s")
+
+	packageRoot := t.TempDir()
+	writeTestFile(t, filepath.Join(packageRoot, "manifest.yml"), "format_version: 2.0.0\nname: test\nversion: 1.0.0\ntype: integration\n")
+	buildManifest := "dependencies:\n  ecs:\n    reference: git@v8.0.0\n"
+	for _, setting := range ecsSettings {
+		buildManifest += "    " + setting + "\n"
+	}
+	writeTestFile(t, filepath.Join(packageRoot, "_dev", "build", "build.yml"), buildManifest)
+	writeTestFile(t, filepath.Join(packageRoot, "data_stream", "test", "fields", "ecs.yml"), "- name: container.id\n  external: ecs\n")
+	writeTestFile(t, filepath.Join(packageRoot, "data_stream", "test", "fields", "base.yml"), "- name: message\n  type: text\n")
+	return packageRoot
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
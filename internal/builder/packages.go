@@ -31,6 +31,14 @@ type BuildOptions struct {
 	CreateZip      bool
 	SignPackage    bool
 	SkipValidation bool
+
+	// ImportedFields receives, if set, the number of fields imported from external sources in
+	// each data stream, keyed by data stream name, or by an empty string for package fields.
+	ImportedFields map[string]int
+
+	// SchemaSnapshot is, if set, the path where a snapshot of the schemas used to resolve external
+	// fields is written, to reproduce the build later. It is written out of the built package.
+	SchemaSnapshot string
 }
 
 // BuildDirectory function locates the target build directory. If the directory doesn't exist, it will create it.
@@ -85,6 +93,28 @@ func BuildPackagesDirectory(packageRoot string) (string, error) {
 	return filepath.Join(buildDir, m.Name, m.Version), nil
 }
 
+// FindBuiltPackageDirectory function locates the directory of the built package, without creating it.
+// It returns false if the package hasn't been built.
+func FindBuiltPackageDirectory(packageRoot string) (string, bool, error) {
+	buildDir, found, err := FindBuildPackagesDirectory()
+	if err != nil || !found {
+		return "", false, err
+	}
+	m, err := packages.ReadPackageManifestFromPackageRoot(packageRoot)
+	if err != nil {
+		return "", false, errors.Wrapf(err, "reading package manifest failed (path: %s)", packageRoot)
+	}
+	builtPackageDir := filepath.Join(buildDir, m.Name, m.Version)
+	_, err = os.Stat(builtPackageDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return builtPackageDir, true, nil
+}
+
 // buildPackagesZipPath function locates the target zipped package path.
 func buildPackagesZipPath(packageRoot string) (string, error) {
 	buildDir, err := buildPackagesRootDirectory()
@@ -150,6 +180,12 @@ func BuildPackage(options BuildOptions) (string, error) {
 	}
 	logger.Debugf("Build directory: %s\n", destinationDir)
 
+	// Fields files of the previous build are kept to report type changes of imported fields.
+	previousFields, err := readFieldsFiles(destinationDir)
+	if err != nil {
+		return "", errors.Wrap(err, "reading fields files of previous build failed")
+	}
+
 	logger.Debugf("Clear target directory (path: %s)", destinationDir)
 	err = files.ClearDir(destinationDir)
 	if err != nil {
@@ -175,7 +211,7 @@ func BuildPackage(options BuildOptions) (string, error) {
 	}
 
 	logger.Debug("Resolve external fields")
-	err = resolveExternalFields(options.PackageRoot, destinationDir)
+	err = resolveExternalFields(options.PackageRoot, destinationDir, previousFields, options.ImportedFields, options.SchemaSnapshot)
 	if err != nil {
 		return "", errors.Wrap(err, "resolving external fields failed")
 	}
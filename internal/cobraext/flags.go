@@ -44,6 +44,9 @@ const (
 	BenchWithTestSamplesFlagName        = "use-test-samples"
 	BenchWithTestSamplesFlagDescription = "use test samples for the benchmarks"
 
+	BuildSchemaSnapshotFlagName        = "schema-snapshot"
+	BuildSchemaSnapshotFlagDescription = "write a snapshot of the schemas used to resolve external fields to this path"
+
 	BuildSkipValidationFlagName        = "skip-validation"
 	BuildSkipValidationFlagDescription = "skip validation of the built package, use only if all validation issues have been acknowledged"
 
@@ -34,6 +34,10 @@ var (
 	// elasticPackageDataHome is the name of the environment variable used to override data folder for elastic-package
 	elasticPackageDataHome = environment.WithElasticPackagePrefix("DATA_HOME")
 
+	// fieldsCacheDirEnv is the name of the environment variable used to override the directory with cached fields,
+	// independently of the data folder
+	fieldsCacheDirEnv = environment.WithElasticPackagePrefix("FIELDS_CACHE_DIR")
+
 	serviceLogsDir               = filepath.Join(temporaryDir, "service_logs")
 	kubernetesDeployerDir        = filepath.Join(deployerDir, "kubernetes")
 	terraformDeployerDir         = filepath.Join(deployerDir, "terraform")
@@ -117,7 +121,12 @@ func (loc LocationManager) ServiceLogDir() string {
 }
 
 // FieldsCacheDir returns the directory with cached fields
+// If a environment variable named as in fieldsCacheDirEnv is present,
+// the value is used as is, overriding the default location.
 func (loc LocationManager) FieldsCacheDir() string {
+	if customDir := os.Getenv(fieldsCacheDirEnv); customDir != "" {
+		return customDir
+	}
 	return filepath.Join(loc.stackPath, fieldsCachedDir)
 }
 
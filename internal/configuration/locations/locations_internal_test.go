@@ -55,3 +55,13 @@ func Test_configurationDirOverride(t *testing.T) {
 	assert.Equal(t, expected, actual)
 	os.Setenv(elasticPackageDataHome, "")
 }
+
+func Test_FieldsCacheDirOverride(t *testing.T) {
+	loc := LocationManager{stackPath: "/tmp/foobar"}
+
+	t.Setenv(fieldsCacheDirEnv, "")
+	assert.Equal(t, filepath.Join("/tmp/foobar", fieldsCachedDir), loc.FieldsCacheDir())
+
+	t.Setenv(fieldsCacheDirEnv, "/mnt/cache/fields")
+	assert.Equal(t, "/mnt/cache/fields", loc.FieldsCacheDir())
+}
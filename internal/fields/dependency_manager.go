@@ -5,18 +5,28 @@
 package fields
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/Masterminds/semver"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 
 	"github.com/elastic/elastic-package/internal/common"
 	"github.com/elastic/elastic-package/internal/configuration/locations"
+	"github.com/elastic/elastic-package/internal/environment"
 	"github.com/elastic/elastic-package/internal/logger"
 	"github.com/elastic/elastic-package/internal/packages/buildmanifest"
 )
@@ -26,176 +36,1993 @@ const (
 	gitReferencePrefix = "git@"
 
 	ecsSchemaFile = "ecs_nested.yml"
-	ecsSchemaURL  = "https://raw.githubusercontent.com/elastic/ecs/%s/generated/ecs/%s"
+	ecsSchemaURL  = "https://raw.githubusercontent.com/elastic/ecs/%s/%s/%s"
 )
 
+// ecsSchemaURLEnv is the name of the environment variable used to override the URL template of the ECS schema.
+// The template must contain two "%s" placeholders, replaced with the Git reference and the schema file name.
+var ecsSchemaURLEnv = environment.WithElasticPackagePrefix("ECS_SCHEMA_URL")
+
+// ecsExperimentalSchemaURLEnv is the name of the environment variable used to override the URL template of the
+// experimental ECS schema, in the same format as the one defined in ecsSchemaURLEnv.
+var ecsExperimentalSchemaURLEnv = environment.WithElasticPackagePrefix("ECS_EXPERIMENTAL_SCHEMA_URL")
+
+// ecsSchemaHeadersEnv is the name of the environment variable with static headers added to the requests to
+// download the ECS schema from mirrors, as "Name: value" pairs separated by semicolons. They are sent for all the
+// references.
+var ecsSchemaHeadersEnv = environment.WithElasticPackagePrefix("ECS_SCHEMA_HEADERS")
+
+// forceSchemaRefreshEnv is the name of the environment variable used to ignore cached schemas,
+// so they are downloaded again and the cache is rewritten.
+var forceSchemaRefreshEnv = environment.WithElasticPackagePrefix("FORCE_SCHEMA_REFRESH")
+
+// sharedFieldsCacheDirsEnv is the name of the environment variable with a list of read-only cache directories,
+// separated by the OS path list separator, where schemas are looked for when they are not in the fields cache.
+var sharedFieldsCacheDirsEnv = environment.WithElasticPackagePrefix("SHARED_FIELDS_CACHE_DIRS")
+
+// schemaCacheMaxAgeEnv is the name of the environment variable with the maximum age of cached schemas of moving
+// references, after which a refresh is suggested.
+var schemaCacheMaxAgeEnv = environment.WithElasticPackagePrefix("SCHEMA_CACHE_MAX_AGE")
+
 // DependencyManager is responsible for resolving external field dependencies.
 type DependencyManager struct {
-	schema map[string][]FieldDefinition
+	// deps contains the dependencies the schemas are loaded from.
+	deps buildmanifest.Dependencies
+
+	// schemaMutex protects schema and indexes, that can be updated when
+	// schemas are loaded on first use.
+	schemaMutex sync.RWMutex
+	schema      map[string][]FieldDefinition
+
+	// indexes contains the path indexes of loaded schemas.
+	indexes map[string]*schemaIndex
+
+	// allowBeta allows to import fields in beta.
+	allowBeta bool
+
+	// strictTypes makes injection fail when imported fields are declared with incompatible types.
+	strictTypes bool
+
+	// strictDuplicates makes injection fail when the same field is imported more than once.
+	strictDuplicates bool
+
+	// strictTypeChanges makes injection fail when imported fields have a different type than in
+	// the previous output.
+	strictTypeChanges bool
+
+	// specVersion is the version of the spec imported fields must comply with, if set.
+	specVersion *semver.Version
+
+	// importMode determines the attributes included in imported fields.
+	importMode ImportMode
+
+	// nameMetadata preserves the ECS name metadata of imported fields.
+	nameMetadata bool
+
+	// overrides contains the index of the local definitions resolved instead of the ones
+	// of the ECS schema, if any.
+	overrides *schemaIndex
+
+	// descriptions contains the descriptions overlaid on imported fields, keyed by path.
+	descriptions map[string]string
+
+	// fieldSettings contains the settings of imported fields defined in the build manifest, keyed by path.
+	fieldSettings map[string]buildmanifest.FieldSettings
+
+	// cachedSchemasOnly limits the schemas used to the ones found in the caches.
+	cachedSchemasOnly bool
+
+	// ctx is the context the dependency manager was created with, used to load
+	// schemas on first use.
+	ctx context.Context
+
+	// lazy delays loading the schemas of the dependencies until they are used. lazyOnce
+	// ensures that they are loaded only once, and lazyErr keeps the error found, if any.
+	lazy     bool
+	lazyOnce sync.Once
+	lazyErr  error
+
+	// sharedSchemas shares the loaded schemas with other dependency managers of the process.
+	sharedSchemas bool
+
+	// dataStreams contains the dependency managers built for data streams overriding
+	// the ECS reference.
+	dataStreams map[string]*DependencyManager
+
+	// transformedMutex protects transformed, the cache of imported fields
+	// already converted to their MapStr representation, keyed by schema and path.
+	transformedMutex sync.Mutex
+	transformed      map[string]common.MapStr
+}
+
+// DependencyManagerOption represents an optional flag that can be passed to CreateFieldDependencyManager.
+type DependencyManagerOption func(*DependencyManager) error
+
+// WithLazySchemaLoading configures the dependency manager to load the schemas of the dependencies the first time
+// they are used, instead of when creating it. Packages without external fields don't need to download nor read
+// cached schemas then. Errors loading the schemas are returned by the first method using them.
+func WithLazySchemaLoading() DependencyManagerOption {
+	return func(dm *DependencyManager) error {
+		dm.lazy = true
+		return nil
+	}
+}
+
+// ErrSchemaNotCached is the error returned when a schema isn't found in the caches and the dependency manager
+// only uses cached schemas.
+var ErrSchemaNotCached = errors.New("schema not cached")
+
+// WithCachedSchemasOnly configures the dependency manager to only use cached schemas, without downloading them.
+// Schemas of moving references are not revalidated either. This allows to resolve fields offline. Loading a schema
+// not found in the caches fails with an error wrapping ErrSchemaNotCached.
+func WithCachedSchemasOnly() DependencyManagerOption {
+	return func(dm *DependencyManager) error {
+		dm.cachedSchemasOnly = true
+		return nil
+	}
+}
+
+// WithLocalOverrides configures the dependency manager to resolve fields imported from the ECS schema (with
+// `external: ecs`) with the definitions found in the given fields file, if defined there. This allows to use
+// local patches of ECS fields, like fields not released yet. Other fields are imported from ECS as usual.
+func WithLocalOverrides(path string) DependencyManagerOption {
+	return func(dm *DependencyManager) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return errors.Wrap(err, "can't open local overrides")
+		}
+		defer f.Close()
+
+		defs, err := parseSchemaReader(path, f)
+		if err != nil {
+			return errors.Wrap(err, "can't load local overrides")
+		}
+		dm.overrides = newSchemaIndex(defs)
+		return nil
+	}
+}
+
+// ecsNameMetadataAttributes are the attributes of ECS definitions with alternative forms of the name of the fields.
+var ecsNameMetadataAttributes = []string{"flat_name", "dashed_name"}
+
+// WithECSNameMetadata configures the dependency manager to preserve the flat_name and dashed_name attributes of
+// imported fields. This is ECS-specific metadata, used by some tools consuming fields files, but not needed for mappings.
+func WithECSNameMetadata() DependencyManagerOption {
+	return func(dm *DependencyManager) error {
+		dm.nameMetadata = true
+		return nil
+	}
+}
+
+// WithStrictTypes configures the dependency manager to fail when imported fields are declared locally with types
+// incompatible with the imported ones. Otherwise the imported types are enforced, with a warning.
+func WithStrictTypes() DependencyManagerOption {
+	return func(dm *DependencyManager) error {
+		dm.strictTypes = true
+		return nil
+	}
+}
+
+// WithStrictTypeChanges configures the dependency manager to fail when imported fields resolve to a different type
+// than in the previous output given to InjectFields with WithPreviousFields. Otherwise type changes are reported with
+// a warning.
+func WithStrictTypeChanges() DependencyManagerOption {
+	return func(dm *DependencyManager) error {
+		dm.strictTypeChanges = true
+		return nil
+	}
+}
+
+// WithStrictDuplicates configures the dependency manager to fail when the same field is imported more than once
+// in the same definitions. Otherwise duplicated definitions are merged, with a warning, the last one taking precedence.
+func WithStrictDuplicates() DependencyManagerOption {
+	return func(dm *DependencyManager) error {
+		dm.strictDuplicates = true
+		return nil
+	}
+}
+
+// WithTargetSpecVersion configures the dependency manager to drop attributes of imported fields that are
+// not supported by the given version of the package spec.
+func WithTargetSpecVersion(version string) DependencyManagerOption {
+	return func(dm *DependencyManager) error {
+		sv, err := semver.NewVersion(version)
+		if err != nil {
+			return fmt.Errorf("invalid version %q: %v", version, err)
+		}
+		dm.specVersion = sv
+		return nil
+	}
+}
+
+// ImportMode determines the attributes included in imported fields.
+type ImportMode int
+
+const (
+	// ImportModeDefault includes the attributes relevant for mappings and the description of the fields.
+	ImportModeDefault ImportMode = iota
+
+	// ImportModeBuild includes only the attributes relevant for mappings, omitting documentation-only
+	// attributes like descriptions and examples, to keep the built fields files lean.
+	ImportModeBuild
+
+	// ImportModeDocs includes also documentation-only attributes, like examples.
+	ImportModeDocs
+)
+
+// importModes are the import modes, by the names used in build manifests.
+var importModes = map[string]ImportMode{
+	"default": ImportModeDefault,
+	"build":   ImportModeBuild,
+	"docs":    ImportModeDocs,
+}
+
+// ParseImportMode function returns the import mode with the given name, as used in build manifests. An empty
+// name selects the default mode.
+func ParseImportMode(name string) (ImportMode, error) {
+	if name == "" {
+		return ImportModeDefault, nil
+	}
+	mode, found := importModes[name]
+	if !found {
+		return ImportModeDefault, fmt.Errorf(`invalid import mode "%s" (expected one of: build, default, docs)`, name)
+	}
+	return mode, nil
+}
+
+// docsOnlyAttributes are the attributes of imported fields not relevant for mappings.
+var docsOnlyAttributes = []string{"description", "example"}
+
+// WithImportMode configures the attributes included in imported fields, depending on whether
+// they are used to build mappings or to generate documentation.
+func WithImportMode(mode ImportMode) DependencyManagerOption {
+	return func(dm *DependencyManager) error {
+		dm.importMode = mode
+		return nil
+	}
+}
+
+// CreateFieldDependencyManager function creates a new instance of the DependencyManager.
+// Schema downloads are aborted when the given context is canceled, including downloads of
+// schemas loaded lazily. Data streams with their own ECS reference get their
+// own dependency manager, with the same settings, available with ForDataStream.
+func CreateFieldDependencyManager(ctx context.Context, deps buildmanifest.Dependencies, opts ...DependencyManagerOption) (*DependencyManager, error) {
+	dm, err := newDependencyManager(ctx, deps, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for dataStream, reference := range deps.ECS.DataStreams {
+		if reference == "" {
+			continue
+		}
+		logger.Debugf("Data stream %s overrides ECS dependency with reference %s", dataStream, reference)
+
+		dsDeps := deps
+		dsDeps.ECS.Reference = reference
+		dsDeps.ECS.DataStreams = nil
+		dsm, err := newDependencyManager(ctx, dsDeps, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't create field dependency manager for data stream %s", dataStream)
+		}
+
+		if dm.dataStreams == nil {
+			dm.dataStreams = make(map[string]*DependencyManager)
+		}
+		dm.dataStreams[dataStream] = dsm
+	}
+	return dm, nil
+}
+
+// parseSchemaReader parses the field definitions of a schema read from the given reader, in the format of
+// fields files or of the nested ECS schema. The name of the schema is used in error messages.
+func parseSchemaReader(name string, r io.Reader) ([]FieldDefinition, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read schema %s", name)
+	}
+	fields, err := parseECSFieldsSchema(content)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't parse schema %s", name)
+	}
+	return fields, nil
+}
+
+func newDependencyManager(ctx context.Context, deps buildmanifest.Dependencies, opts []DependencyManagerOption) (*DependencyManager, error) {
+	dm := &DependencyManager{deps: deps, ctx: ctx}
+	for _, opt := range opts {
+		if err := opt(dm); err != nil {
+			return nil, err
+		}
+	}
+	if dm.cachedSchemasOnly {
+		ctx = context.WithValue(ctx, cachedSchemasOnlyKey{}, true)
+	}
+	dm.ctx = ctx
+	if deps.ECS.AllowBeta {
+		dm.allowBeta = true
+	}
+	fieldSettings, err := indexFieldSettings(deps.ECS.Fields)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid settings of imported fields")
+	}
+	dm.fieldSettings = fieldSettings
+	if dm.lazy {
+		return dm, nil
+	}
+
+	schema, err := dm.buildFieldsSchema(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't build fields schema")
+	}
+	for name, loaded := range schema {
+		dm.addLoadedSchema(name, loaded)
+	}
+	return dm, nil
+}
+
+// addSchema adds the definitions of a schema to the dependency manager and indexes them, so imported
+// fields are found without traversing the whole schema. It must be called with schemaMutex locked, or
+// before the dependency manager is used.
+func (dm *DependencyManager) addSchema(name string, defs []FieldDefinition) {
+	dm.addLoadedSchema(name, dm.indexSchema(defs))
+}
+
+// indexSchema builds the index of the definitions of a schema.
+func (dm *DependencyManager) indexSchema(defs []FieldDefinition) loadedSchema {
+	if len(defs) == 0 {
+		return loadedSchema{}
+	}
+	return loadedSchema{defs: defs, index: newSchemaIndex(defs)}
+}
+
+// addLoadedSchema adds a schema already indexed to the dependency manager, with the same conditions
+// as addSchema.
+func (dm *DependencyManager) addLoadedSchema(name string, loaded loadedSchema) {
+	if dm.schema == nil {
+		dm.schema = make(map[string][]FieldDefinition)
+	}
+	dm.schema[name] = nil
+	if loaded.index == nil {
+		return
+	}
+
+	if dm.indexes == nil {
+		dm.indexes = make(map[string]*schemaIndex)
+	}
+	dm.indexes[name] = loaded.index
+	dm.schema[name] = loaded.defs
+}
+
+// getSchema returns the definitions of a schema and its index, if any.
+func (dm *DependencyManager) getSchema(schemaName string) ([]FieldDefinition, *schemaIndex, bool, error) {
+	err := dm.loadLazySchemas()
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	dm.schemaMutex.RLock()
+	defer dm.schemaMutex.RUnlock()
+	schema, found := dm.schema[schemaName]
+	return schema, dm.indexes[schemaName], found, nil
+}
+
+// loadLazySchemas loads the schemas of the dependencies if their loading has been delayed until first use.
+func (dm *DependencyManager) loadLazySchemas() error {
+	if !dm.lazy {
+		return nil
+	}
+	dm.lazyOnce.Do(func() {
+		logger.Debugf("Loading schemas of dependencies on first use")
+		schema, err := dm.buildFieldsSchema(dm.ctx)
+		if err != nil {
+			dm.lazyErr = errors.Wrap(err, "can't build fields schema")
+			return
+		}
+
+		dm.schemaMutex.Lock()
+		defer dm.schemaMutex.Unlock()
+		for name, loaded := range schema {
+			dm.addLoadedSchema(name, loaded)
+		}
+	})
+	return dm.lazyErr
+}
+
+// ForDataStream method returns the dependency manager to use for the fields of the given data stream.
+// It is the manager of the package, unless the data stream overrides its dependencies.
+func (dm *DependencyManager) ForDataStream(dataStream string) *DependencyManager {
+	if dm == nil {
+		return nil
+	}
+	if dsm, found := dm.dataStreams[dataStream]; found {
+		return dsm
+	}
+	return dm
+}
+
+// buildFieldsSchema loads and indexes the schemas of the dependencies.
+func (dm *DependencyManager) buildFieldsSchema(ctx context.Context) (map[string]loadedSchema, error) {
+	schema := map[string]loadedSchema{}
+	ecsSchema, err := dm.loadECSSchema(ctx, dm.deps.ECS)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't load fields")
+	}
+	schema[ecsSchemaName] = ecsSchema
+	return schema, nil
+}
+
+// loadECSFieldsSchema loads the definitions of the ECS schema of a dependency. Attributes not modeled by
+// FieldDefinition are only kept in the definitions if keepExtra is true, as most of them are documentation
+// metadata not used when importing fields.
+func loadECSFieldsSchema(ctx context.Context, dep buildmanifest.ECSDependency, keepExtra bool) ([]FieldDefinition, error) {
+	if dep.Reference == "" {
+		logger.Debugf("ECS dependency isn't defined")
+		return nil, nil
+	}
+
+	schemaFile, err := ecsSchemaFileName(dep)
+	if err != nil {
+		return nil, err
+	}
+
+	warnUntestedECSReference(dep.Reference)
+	if dep.Experimental {
+		logger.Warnf("Using the experimental ECS schema for reference %s, its fields are unstable and can change or be removed at any time", dep.Reference)
+	}
+	content, err := readECSFieldsSchemaFile(ctx, dep, schemaFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading ECS fields schema file")
+	}
+
+	reference := normalizeReference(dep.Reference)
+	schemaID := ecsSchemaName + "@" + reference + "/" + schemaFile
+	if dep.Experimental {
+		schemaID = ecsSchemaName + "@" + reference + "/" + ecsExperimentalDir + "/" + schemaFile
+	}
+	parse := parseECSFieldsSchema
+	if keepExtra {
+		schemaID += "+extra"
+	} else {
+		parse = func(content []byte) ([]FieldDefinition, error) {
+			fields, err := parseECSFieldsSchema(content)
+			if err != nil {
+				return nil, err
+			}
+			dropExtraAttributes(fields)
+			return fields, nil
+		}
+	}
+	fields, err := parsedSchemas.parse(schemaID, content, parse)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't parse ECS schema file (file: %s)", schemaFile)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("ECS schema file doesn't contain field definitions (file: %s)", schemaFile)
+	}
+	return fields, nil
+}
+
+// dropExtraAttributes removes the attributes not modeled by FieldDefinition from the given definitions,
+// their child fields and their multi-fields.
+func dropExtraAttributes(defs []FieldDefinition) {
+	for i := range defs {
+		defs[i].Extra = nil
+		dropExtraAttributes(defs[i].Fields)
+		dropExtraAttributes(defs[i].MultiFields)
+	}
+}
+
+// keepsExtraAttributes returns true if the dependency manager uses attributes of ECS definitions not
+// modeled by FieldDefinition, as the name metadata.
+func (dm *DependencyManager) keepsExtraAttributes() bool {
+	return dm.nameMetadata
+}
+
+// ecsSchemaFileName returns the name of the generated ECS file to import fields from.
+func ecsSchemaFileName(dep buildmanifest.ECSDependency) (string, error) {
+	if dep.SchemaFile == "" {
+		return ecsSchemaFile, nil
+	}
+
+	ext := filepath.Ext(dep.SchemaFile)
+	if filepath.Base(dep.SchemaFile) != dep.SchemaFile || strings.ContainsAny(dep.SchemaFile, `/\`) || (ext != ".yml" && ext != ".yaml") {
+		return "", fmt.Errorf(`invalid ECS schema file "%s" defined in build manifest "_dev/build/build.yml" (name of a YAML file expected)`, dep.SchemaFile)
+	}
+	return dep.SchemaFile, nil
+}
+
+func readECSFieldsSchemaFile(ctx context.Context, dep buildmanifest.ECSDependency, schemaFile string) ([]byte, error) {
+	source, err := newSchemaSource(dep.Reference)
+	if err != nil {
+		return nil, errors.Wrapf(err, `invalid ECS reference "%s" defined in build manifest "_dev/build/build.yml"`, dep.Reference)
+	}
+	if dep.Experimental {
+		gitSource, ok := source.(gitSchemaSource)
+		if !ok {
+			return nil, fmt.Errorf(`experimental schemas can only be imported from the ECS repository (reference: %s)`, dep.Reference)
+		}
+		gitSource.experimental = true
+		source = gitSource
+	}
+
+	loc, err := locations.NewLocationManager()
+	if err != nil {
+		return nil, errors.Wrap(err, "error fetching profile path")
+	}
+	cachedSchemaPath, err := cachedSchemaFilePath(loc.FieldsCacheDir(), ecsSchemaName, source.cacheKey(), schemaFile)
+	if err != nil {
+		return nil, err
+	}
+	cachedOnly := cachedSchemasOnly(ctx)
+	var content []byte
+	if forceSchemaRefresh() && !cachedOnly {
+		logger.Debugf("Forced refresh of cached schema (%s is set): %s", forceSchemaRefreshEnv, cachedSchemaPath)
+		err = os.ErrNotExist
+	} else {
+		content, err = readCachedSchema(cachedSchemaPath)
+		if err == nil {
+			logger.Debugf("Schema cache hit: %s", cachedSchemaPath)
+			schemaCacheCounters.hits.Add(1)
+			if !source.pinned() && !cachedOnly {
+				content, err = revalidateCachedSchema(ctx, source, dep.Reference, schemaFile, cachedSchemaPath, content)
+				if err != nil {
+					return nil, err
+				}
+			}
+		} else if errors.Is(err, os.ErrNotExist) {
+			logger.Debugf("Schema cache miss (not present): %s", cachedSchemaPath)
+			content, err = readSharedCachedSchema(ecsSchemaName, source.cacheKey(), schemaFile)
+			if err == nil {
+				schemaCacheCounters.sharedHits.Add(1)
+			}
+		}
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		schemaCacheCounters.misses.Add(1)
+		if cachedOnly {
+			return nil, errors.Wrapf(ErrSchemaNotCached, "reference %s", dep.Reference)
+		}
+		logger.Debugf("Pulling ECS dependency using reference: %s", dep.Reference)
+		var etag string
+		if conditionalSource, ok := source.(conditionalSchemaSource); ok && !source.pinned() {
+			var downloaded schemaDownload
+			downloaded, err = conditionalSource.downloadIfModified(ctx, schemaFile, "")
+			content, etag = downloaded.content, downloaded.etag
+		} else {
+			content, err = source.download(ctx, schemaFile)
+		}
+		if err != nil {
+			return nil, err
+		}
+		cacheDownloadedSchema(cachedSchemaPath, content, etag)
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "can't read cached schema (path: %s)", cachedSchemaPath)
+	}
+
+	return content, nil
+}
+
+// cacheDownloadedSchema writes a downloaded schema in the cache, with its ETag, if any, to revalidate it later.
+// Caching is optional, builds can continue with the downloaded content, e.g. on read-only file systems.
+func cacheDownloadedSchema(cachedSchemaPath string, content []byte, etag string) {
+	logger.Debugf("Downloaded %d bytes", len(content))
+	schemaCacheCounters.downloads.Add(1)
+	schemaCacheCounters.downloadedBytes.Add(uint64(len(content)))
+
+	err := writeCachedSchema(cachedSchemaPath, content)
+	if err == nil {
+		err = writeCachedETag(cachedSchemaPath, etag)
+	}
+	if err != nil {
+		logger.Warnf("Downloaded schema couldn't be cached, caching skipped: %v", err)
+	}
+}
+
+// schemaSource is a location schema files can be downloaded from.
+type schemaSource interface {
+	// cacheKey returns the element of the path of cached files identifying the source.
+	cacheKey() string
+
+	// download downloads the given schema file, until the context is canceled.
+	download(ctx context.Context, schemaFile string) ([]byte, error)
+
+	// pinned returns true if the reference always points to the same content, like tags or
+	// commit SHAs, and false for moving references, like branches.
+	pinned() bool
+}
+
+// gitSchemaSource downloads schema files of a Git reference of the ECS repository.
+type gitSchemaSource struct {
+	reference string
+
+	// experimental selects the experimental schema files, generated from the schemas in development.
+	experimental bool
+}
+
+// experimentalCacheKeyPrefix is the prefix of the cache keys of experimental schema files.
+const experimentalCacheKeyPrefix = "experimental@"
+
+func (s gitSchemaSource) cacheKey() string {
+	if s.experimental {
+		return experimentalCacheKeyPrefix + s.reference
+	}
+	return s.reference
+}
+
+// githubTokenEnvs are the names of the environment variables with the GitHub token used to download schemas,
+// in order of precedence.
+var githubTokenEnvs = []string{environment.WithElasticPackagePrefix("GITHUB_TOKEN"), "GITHUB_TOKEN"}
+
+// githubTokenHosts are the hosts the GitHub token is sent to, so it isn't leaked to mirrors.
+var githubTokenHosts = map[string]bool{
+	"raw.githubusercontent.com": true,
+	"github.com":                true,
+}
+
+// githubToken returns the GitHub token defined in the environment, if any.
+func githubToken() string {
+	for _, env := range githubTokenEnvs {
+		if token := os.Getenv(env); token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+// pinnedGitReferencePattern matches Git references considered pinned: commit SHAs and release tags.
+var pinnedGitReferencePattern = regexp.MustCompile(`^([0-9a-f]{7,40}|v\d+\.\d+\.\d+([-+.].*)?)$`)
+
+func (s gitSchemaSource) pinned() bool {
+	return pinnedGitReferencePattern.MatchString(s.reference)
+}
+
+// download tries to download the schema file from the configured URLs, in order, until one of them succeeds.
+func (s gitSchemaSource) download(ctx context.Context, schemaFile string) ([]byte, error) {
+	downloaded, err := s.downloadIfModified(ctx, schemaFile, "")
+	if err != nil {
+		return nil, err
+	}
+	return downloaded.content, nil
 }
 
-// CreateFieldDependencyManager function creates a new instance of the DependencyManager.
-func CreateFieldDependencyManager(deps buildmanifest.Dependencies) (*DependencyManager, error) {
-	schema, err := buildFieldsSchema(deps)
+// downloadIfModified downloads the schema file as download does. If an ETag is given, the schema file is only
+// downloaded if it doesn't match, otherwise the result is marked as not modified.
+func (s gitSchemaSource) downloadIfModified(ctx context.Context, schemaFile, etag string) (schemaDownload, error) {
+	urlTemplates, err := ecsSchemaURLTemplates(s.reference, s.experimental)
+	if err != nil {
+		return schemaDownload{}, err
+	}
+
+	var tried []string
+	var lastErr error
+	notFound := true
+	for _, urlTemplate := range urlTemplates {
+		url := fmt.Sprintf(urlTemplate, s.reference, schemaFile)
+		tried = append(tried, url)
+		downloaded, statusCode, err := downloadSchemaURL(ctx, url, etag)
+		if err == nil {
+			return downloaded, nil
+		}
+		if ctx.Err() != nil || len(urlTemplates) == 1 {
+			return schemaDownload{}, err
+		}
+		logger.Debugf("Downloading schema failed, trying next URL: %v", err)
+		if statusCode != http.StatusNotFound {
+			notFound = false
+		}
+		lastErr = err
+	}
+	if notFound {
+		return schemaDownload{}, fmt.Errorf("unsatisfied ECS dependency, reference defined in build manifest doesn't exist (HTTP StatusNotFound, URLs tried: %s)", strings.Join(tried, ", "))
+	}
+	return schemaDownload{}, errors.Wrapf(lastErr, "can't download the online schema (URLs tried: %s)", strings.Join(tried, ", "))
+}
+
+// schemaDownload is the result of downloading a schema file.
+type schemaDownload struct {
+	content []byte
+	// etag is the ETag of the downloaded content, if provided by the server.
+	etag string
+	// notModified is true if the content matches the ETag of the request, and wasn't downloaded.
+	notModified bool
+}
+
+// downloadSchemaURL downloads a schema file from the given URL. It also returns the HTTP status code of the
+// response, if any.
+func downloadSchemaURL(ctx context.Context, url, etag string) (schemaDownload, int, error) {
+	logger.Debugf("Schema URL: %s", url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return schemaDownload{}, 0, errors.Wrapf(err, "invalid schema URL: %s", url)
+	}
+	req.Header.Set("User-Agent", schemaUserAgent())
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	token := githubToken()
+	if token != "" && githubTokenHosts[req.URL.Hostname()] {
+		logger.Debugf("Using GitHub token to download the schema")
+		req.Header.Set("Authorization", "token "+token)
+	}
+	if !githubTokenHosts[req.URL.Hostname()] {
+		headers, err := ecsSchemaHeaders()
+		if err != nil {
+			return schemaDownload{}, 0, err
+		}
+		for name, values := range headers {
+			logger.Debugf("Using schema request header %s: %s", name, redactedHeaderValue(name, strings.Join(values, ", ")))
+			req.Header[name] = values
+		}
+	}
+	resp, err := doWithRateLimitRetries(schemaHTTPClient(), req)
+	if err != nil {
+		return schemaDownload{}, 0, errors.Wrapf(err, "can't download the online schema (URL: %s)", url)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return schemaDownload{etag: etag, notModified: true}, resp.StatusCode, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return schemaDownload{}, resp.StatusCode, fmt.Errorf("unsatisfied ECS dependency, reference defined in build manifest doesn't exist (HTTP StatusNotFound, URL: %s)", url)
+	case (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) && req.Header.Get("Authorization") != "":
+		return schemaDownload{}, resp.StatusCode, fmt.Errorf("authentication failed, check the GitHub token (HTTP status code: %d, URL: %s)", resp.StatusCode, url)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return schemaDownload{}, resp.StatusCode, fmt.Errorf("authentication required, set a GitHub token in %s or %s (HTTP status code: %d, URL: %s)", githubTokenEnvs[0], githubTokenEnvs[1], resp.StatusCode, url)
+	case resp.StatusCode != http.StatusOK:
+		return schemaDownload{}, resp.StatusCode, fmt.Errorf("unexpected HTTP status code: %d (URL: %s)", resp.StatusCode, url)
+	}
+
+	content, err := io.ReadAll(newProgressReader(resp.Body, resp.ContentLength))
+	if err != nil {
+		return schemaDownload{}, resp.StatusCode, errors.Wrapf(err, "can't read schema content (URL: %s)", url)
+	}
+	return schemaDownload{content: content, etag: resp.Header.Get("ETag")}, resp.StatusCode, nil
+}
+
+// ecsSchemaHeaders returns the headers defined in the environment variable in ecsSchemaHeadersEnv.
+func ecsSchemaHeaders() (http.Header, error) {
+	headers := make(http.Header)
+	for _, entry := range strings.Split(os.Getenv(ecsSchemaHeadersEnv), ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, value, found := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf(`invalid value of %s ("Name: value" pairs separated by semicolons expected)`, ecsSchemaHeadersEnv)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// secretHeaderPattern matches the names of headers whose values are considered secret.
+var secretHeaderPattern = regexp.MustCompile(`(?i)auth|token|key|secret|passw|cookie|session|credential`)
+
+// redactedHeaderValue returns the value of a header to be logged, hiding it if it looks secret.
+func redactedHeaderValue(name, value string) string {
+	if secretHeaderPattern.MatchString(name) {
+		return "<redacted>"
+	}
+	return value
+}
+
+// rateLimitRetries is the maximum number of times a request is retried when rate-limited.
+const rateLimitRetries = 3
+
+// rateLimitBackoff is the delay before retrying rate-limited requests whose response doesn't include
+// a Retry-After header, doubled on every retry. maxRateLimitBackoff caps the delays.
+var (
+	rateLimitBackoff    = time.Second
+	maxRateLimitBackoff = time.Minute
+)
+
+// doWithRateLimitRetries sends a request without body, retrying it when the server responds with
+// HTTP 429, after the delay requested by the server. Retries stop when the context of the request is done.
+func doWithRateLimitRetries(client *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt == rateLimitRetries {
+			return resp, err
+		}
+		delay := retryAfter(resp.Header.Get("Retry-After"), rateLimitBackoff<<attempt)
+		resp.Body.Close()
+
+		logger.Debugf("Rate-limited by server, retrying in %s (URL: %s)", delay, req.URL)
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryAfter returns the delay defined by a Retry-After header, in seconds or as a date, or the
+// given default if it is not defined.
+func retryAfter(header string, defaultDelay time.Duration) time.Duration {
+	delay := defaultDelay
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(header); err == nil {
+		delay = time.Until(date)
+	}
+	if delay < 0 {
+		return 0
+	}
+	if delay > maxRateLimitBackoff {
+		return maxRateLimitBackoff
+	}
+	return delay
+}
+
+// downloadProgressInterval is the number of bytes downloaded between progress reports.
+const downloadProgressInterval = 1 << 20
+
+// progressReader reports the progress of downloads in debug logs.
+type progressReader struct {
+	reader   io.Reader
+	total    int64
+	read     int64
+	reported int64
+}
+
+// newProgressReader returns a reader reporting the progress of reading from r. The total
+// length can be unknown, with a negative value.
+func newProgressReader(r io.Reader, total int64) *progressReader {
+	return &progressReader{reader: r, total: total}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.read-r.reported >= downloadProgressInterval {
+		r.reported = r.read
+		if r.total > 0 {
+			logger.Debugf("Downloading schema: %d of %d bytes (%d%%)", r.read, r.total, r.read*100/r.total)
+		} else {
+			logger.Debugf("Downloading schema: %d bytes", r.read)
+		}
+	}
+	return n, err
+}
+
+// compressedSchemaExt is the extension of compressed schemas in the cache.
+const compressedSchemaExt = ".gz"
+
+// readCachedSchema reads a schema from the cache. Schemas are cached compressed, but plain
+// schemas cached by previous versions are also read.
+func readCachedSchema(cachedSchemaPath string) ([]byte, error) {
+	f, err := os.Open(cachedSchemaPath + compressedSchemaExt)
+	if errors.Is(err, os.ErrNotExist) {
+		return os.ReadFile(cachedSchemaPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't decompress cached schema (path: %s)", f.Name())
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't decompress cached schema (path: %s)", f.Name())
+	}
+	return content, nil
+}
+
+// defaultSchemaCacheMaxAge is the age of cached schemas of moving references after which they are revalidated, or
+// a refresh is suggested.
+const defaultSchemaCacheMaxAge = 24 * time.Hour
+
+// schemaCacheMaxAge returns the maximum age of cached schemas of moving references, defined with the environment
+// variable in schemaCacheMaxAgeEnv, or defaultSchemaCacheMaxAge if not defined.
+func schemaCacheMaxAge() time.Duration {
+	maxAge := defaultSchemaCacheMaxAge
+	if v := os.Getenv(schemaCacheMaxAgeEnv); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			logger.Warnf("invalid value of %s, using default maximum age of cached schemas (%s): %v", schemaCacheMaxAgeEnv, maxAge, err)
+		} else {
+			maxAge = d
+		}
+	}
+	return maxAge
+}
+
+// cachedSchemaAge returns the time since a cached schema was downloaded, or revalidated.
+func cachedSchemaAge(cachedSchemaPath string) (time.Duration, error) {
+	info, err := os.Stat(cachedSchemaPath + compressedSchemaExt)
+	if errors.Is(err, os.ErrNotExist) {
+		info, err = os.Stat(cachedSchemaPath)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(info.ModTime()), nil
+}
+
+// warnStaleCachedSchema warns if a cached schema is older than the maximum age of cached schemas.
+func warnStaleCachedSchema(reference, cachedSchemaPath string) {
+	age, err := cachedSchemaAge(cachedSchemaPath)
+	if err != nil {
+		logger.Debugf("Can't check age of cached schema (path: %s): %v", cachedSchemaPath, err)
+		return
+	}
+	if age > schemaCacheMaxAge() {
+		logger.Warnf("cached schema for moving reference %q was downloaded %s ago, set %s=true to refresh it", reference, age.Round(time.Minute), forceSchemaRefreshEnv)
+	}
+}
+
+// readSharedCachedSchema reads a schema from the shared cache directories defined in sharedFieldsCacheDirsEnv,
+// in order. It returns an os.ErrNotExist error if the schema is not found in any of them.
+func readSharedCachedSchema(elems ...string) ([]byte, error) {
+	for _, dir := range filepath.SplitList(os.Getenv(sharedFieldsCacheDirsEnv)) {
+		if dir == "" {
+			continue
+		}
+		cachedSchemaPath, err := cachedSchemaFilePath(dir, elems...)
+		if err != nil {
+			return nil, err
+		}
+		content, err := readCachedSchema(cachedSchemaPath)
+		if errors.Is(err, os.ErrNotExist) {
+			logger.Debugf("Shared schema cache miss (not present): %s", cachedSchemaPath)
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't read shared cached schema (path: %s)", cachedSchemaPath)
+		}
+		logger.Debugf("Shared schema cache hit: %s", cachedSchemaPath)
+		return content, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// writeCachedSchemaFile writes cached schema files. It can be replaced in tests to simulate write failures.
+var writeCachedSchemaFile = os.WriteFile
+
+// writeCachedSchema stores the content of a downloaded schema in the cache, compressed.
+// Plain schemas cached by previous versions in the same path are removed.
+func writeCachedSchema(cachedSchemaPath string, content []byte) error {
+	cachedSchemaDir := filepath.Dir(cachedSchemaPath)
+	err := os.MkdirAll(cachedSchemaDir, 0755)
+	if err != nil {
+		return errors.Wrapf(err, "can't create cache directories for schema (path: %s)", cachedSchemaDir)
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err = w.Write(content)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return errors.Wrap(err, "can't compress schema")
+	}
+
+	compressedPath := cachedSchemaPath + compressedSchemaExt
+	logger.Debugf("Cache downloaded schema: %s (%d bytes compressed to %d)", compressedPath, len(content), buf.Len())
+	err = writeCachedSchemaFile(compressedPath, buf.Bytes(), 0644)
+	if err != nil {
+		return errors.Wrapf(err, "can't write cached schema (path: %s)", compressedPath)
+	}
+
+	err = os.Remove(cachedSchemaPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrapf(err, "can't remove plain cached schema (path: %s)", cachedSchemaPath)
+	}
+	return nil
+}
+
+// cachedSchemaFilePath returns the path of a cached schema file, ensuring that it is placed in the cache directory.
+func cachedSchemaFilePath(cacheDir string, elems ...string) (string, error) {
+	cachedSchemaPath := filepath.Join(append([]string{cacheDir}, elems...)...)
+	rel, err := filepath.Rel(cacheDir, cachedSchemaPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("cached schema path is out of the cache directory (path: %s)", cachedSchemaPath)
+	}
+	return cachedSchemaPath, nil
+}
+
+// cachedSchemasOnlyKey is the context key set when only cached schemas can be used.
+type cachedSchemasOnlyKey struct{}
+
+// cachedSchemasOnly checks if the context only allows to use cached schemas.
+func cachedSchemasOnly(ctx context.Context) bool {
+	cachedOnly, _ := ctx.Value(cachedSchemasOnlyKey{}).(bool)
+	return cachedOnly
+}
+
+// forceSchemaRefresh checks if cached schemas have to be ignored, as requested with the
+// environment variable defined in forceSchemaRefreshEnv.
+func forceSchemaRefresh() bool {
+	force, _ := strconv.ParseBool(os.Getenv(forceSchemaRefreshEnv))
+	return force
+}
+
+// ecsGeneratedDir is the directory of the ECS repository with the generated schema files.
+const ecsGeneratedDir = "generated/ecs"
+
+// ecsMinimumVersion is the first ECS release including generated schema files that can be imported.
+// Previous releases, like the betas of ECS 1.0, don't include them.
+var ecsMinimumVersion = semver.MustParse("1.0.0")
+
+// ecsExperimentalDir is the directory of the ECS repository with the schemas in development, and the
+// files generated from them, in the same layout as the stable ones.
+const ecsExperimentalDir = "experimental"
+
+// ecsVersionReferencePattern matches Git references named after versions, like release tags
+// ("v8.11.0") or release branches ("8.11").
+var ecsVersionReferencePattern = regexp.MustCompile(`^v?\d+\.\d+(\.\d+)?([-+].*)?$`)
+
+// checkECSReferenceVersion checks that the given Git reference, if named after a version, refers to a
+// release including generated schema files. References not named after versions, like main or commit SHAs,
+// are accepted.
+func checkECSReferenceVersion(gitReference string) error {
+	if !ecsVersionReferencePattern.MatchString(gitReference) {
+		return nil
+	}
+	version, err := semver.NewVersion(gitReference)
+	if err != nil {
+		return nil
+	}
+	if version.LessThan(ecsMinimumVersion) {
+		return fmt.Errorf("ECS reference %s predates the generated schema files (ECS %s or later expected)", gitReference, ecsMinimumVersion)
+	}
+	return nil
+}
+
+// ecsSchemaURLTemplates returns the URL templates used to download the ECS schema for the given Git reference,
+// in the order they have to be tried, taking into account the value of the environment variable defined in
+// ecsSchemaURLEnv, or in ecsExperimentalSchemaURLEnv for experimental schemas. These variables can contain multiple
+// templates separated by commas, e.g. to fall back to GitHub when a mirror is not available. References to
+// releases without generated schema files are rejected, also when the templates are customized.
+func ecsSchemaURLTemplates(gitReference string, experimental bool) ([]string, error) {
+	err := checkECSReferenceVersion(gitReference)
+	if err != nil {
+		return nil, err
+	}
+
+	env := ecsSchemaURLEnv
+	dir := ecsGeneratedDir
+	if experimental {
+		env = ecsExperimentalSchemaURLEnv
+		dir = ecsExperimentalDir + "/" + ecsGeneratedDir
+	}
+	value := os.Getenv(env)
+	if value == "" {
+		return []string{fmt.Sprintf(ecsSchemaURL, "%s", dir, "%s")}, nil
+	}
+	var urlTemplates []string
+	for _, urlTemplate := range strings.Split(value, ",") {
+		urlTemplate = strings.TrimSpace(urlTemplate)
+		if strings.Count(urlTemplate, "%s") != 2 {
+			return nil, fmt.Errorf(`invalid value of %s (two "%%s" placeholders expected, for reference and file name): %s`, env, urlTemplate)
+		}
+		urlTemplates = append(urlTemplates, urlTemplate)
+	}
+	return urlTemplates, nil
+}
+
+// utf8BOM is the byte order mark that can be found at the beginning of UTF-8 files.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+func parseECSFieldsSchema(content []byte) ([]FieldDefinition, error) {
+	// Schemas authored on Windows can contain a byte order mark and CRLF line endings.
+	content = bytes.TrimPrefix(content, utf8BOM)
+	content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+
+	err := checkSchemaShape(content)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid schema")
+	}
+
+	var fields FieldDefinitions
+	err = yaml.Unmarshal(content, &fields)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshalling field body failed")
+	}
+
+	err = validateSchemaDefinitions("", fields)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid schema")
+	}
+	return fields, nil
+}
+
+// InjectFieldsOption represents an optional flag that can be passed to InjectFields.
+type InjectFieldsOption func(*fieldsInjection) error
+
+// WithProvenance configures InjectFields to record in the given map the name of the schema each injected
+// field has been imported from, by path, e.g. to annotate imported fields in documentation. Fields declared
+// locally are not included. Injected definitions are not modified.
+func WithProvenance(provenance map[string]string) InjectFieldsOption {
+	return func(fi *fieldsInjection) error {
+		if provenance == nil {
+			return errors.New("provenance map is nil")
+		}
+		fi.provenance = provenance
+		return nil
+	}
+}
+
+// WithPreviousFields configures InjectFields to compare the types of the injected fields with the ones they had in
+// the given definitions, like the fields files generated by a previous build, reporting the fields whose type changed,
+// e.g. after updating the ECS reference.
+func WithPreviousFields(defs []common.MapStr) InjectFieldsOption {
+	return func(fi *fieldsInjection) error {
+		previous := make(map[string]string)
+		err := collectFieldTypes("", defs, previous)
+		if err != nil {
+			return errors.Wrap(err, "can't read previous fields")
+		}
+		fi.previousTypes = previous
+		return nil
+	}
+}
+
+// injectedField describes an external field resolved by InjectFields.
+type injectedField struct {
+	// path is the full path of the field.
+	path string
+	// fieldType is the type of the field after injection.
+	fieldType string
+	// source is the name of the schema the field has been imported from.
+	source string
+}
+
+// fieldsInjection contains the options and the state of a single InjectFields call.
+type fieldsInjection struct {
+	// injected contains the external fields resolved.
+	injected []injectedField
+
+	// provenance receives the schemas of the injected fields, by path, if set.
+	provenance map[string]string
+
+	// externals contains the fields already imported, by path, to detect duplicated references.
+	externals map[string]*injectedExternal
+
+	// previousTypes contains the types of the fields in the previous output, by path, if set.
+	previousTypes map[string]string
+}
+
+// injectedExternal is an imported field, as found in the injected definitions.
+type injectedExternal struct {
+	def        common.MapStr
+	occurrence string
+
+	// injectedIndex is the position of the field in the resolved external fields.
+	injectedIndex int
+}
+
+// InjectFields function replaces external field references with target definitions.
+func (dm *DependencyManager) InjectFields(defs []common.MapStr, opts ...InjectFieldsOption) ([]common.MapStr, bool, error) {
+	injection := &fieldsInjection{
+		externals: make(map[string]*injectedExternal),
+	}
+	for _, opt := range opts {
+		if err := opt(injection); err != nil {
+			return nil, false, err
+		}
+	}
+	err := checkFieldDirectives("", defs)
+	if err != nil {
+		return nil, false, err
+	}
+	if dm != nil {
+		err = dm.checkDeclaredSchemas(defs)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	updated, changed, err := dm.injectFieldsWithRoot("", defs, nil, injection)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if changed {
+		err = checkObjectCollisions(updated)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if injection.previousTypes != nil {
+		err = dm.checkTypeChanges(injection)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if injection.provenance != nil {
+		for _, injected := range injection.injected {
+			injection.provenance[injected.path] = injected.source
+		}
+	}
+	return updated, changed, nil
+}
+
+// checkDeclaredSchemas checks that the schemas referenced by the external fields in the given definitions
+// are defined as dependencies, so a single error lists all the undeclared schemas, instead of failing on
+// the first field importing from one of them.
+func (dm *DependencyManager) checkDeclaredSchemas(defs []common.MapStr) error {
+	schemaNames := make(map[string]bool)
+	err := collectExternalSchemaNames("", defs, schemaNames)
+	if err != nil {
+		return err
+	}
+	if len(schemaNames) == 0 {
+		return nil
+	}
+
+	var undeclared []string
+	for schemaName := range schemaNames {
+		declared, err := dm.isDeclaredSchema(schemaName)
+		if err != nil {
+			return err
+		}
+		if !declared {
+			undeclared = append(undeclared, schemaName)
+		}
+	}
+	if common.StringSliceContains(undeclared, ecsSchemaName) && dm.deps.ECS.Reference == "" {
+		// The ECS dependency is usually declared, but missing its reference.
+		return errors.New(`external fields reference the "ecs" schema, but the ECS reference is not configured, set "dependencies.ecs.reference" in build manifest "_dev/build/build.yml"`)
+	}
+	if len(undeclared) > 0 {
+		sort.Strings(undeclared)
+		return fmt.Errorf(`external fields reference schemas not defined as package dependencies in build manifest "_dev/build/build.yml": %s`, strings.Join(undeclared, ", "))
+	}
+	return nil
+}
+
+// collectExternalSchemaNames adds the names of the schemas referenced by external fields in the given
+// definitions, and in the definitions of their child fields.
+func collectExternalSchemaNames(root string, defs []common.MapStr, schemaNames map[string]bool) error {
+	for _, def := range defs {
+		external, _ := def.GetValue("external")
+		if schemaName, ok := external.(string); ok {
+			schemaNames[schemaName] = true
+			continue
+		}
+
+		fields, _ := def.GetValue("fields")
+		if fields == nil {
+			continue
+		}
+		fieldPath := buildFieldPath(root, def)
+		fieldsMs, err := groupFields(fieldPath, fields)
+		if err != nil {
+			return err
+		}
+		err = collectExternalSchemaNames(fieldPath, fieldsMs, schemaNames)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isDeclaredSchema returns true if the schema is defined as a dependency.
+func (dm *DependencyManager) isDeclaredSchema(schemaName string) (bool, error) {
+	err := dm.loadLazySchemas()
+	if err != nil {
+		return false, err
+	}
+
+	dm.schemaMutex.RLock()
+	schema, found := dm.schema[schemaName]
+	idx := dm.indexes[schemaName]
+	dm.schemaMutex.RUnlock()
+	if !found {
+		return false, nil
+	}
+	if schemaName == ecsSchemaName && schema == nil && idx == nil && dm.deps.ECS.Reference == "" {
+		return false, nil
+	}
+	return true, nil
+}
+
+// checkTypeChanges reports the injected fields whose type is different from the one they had in the previous output.
+func (dm *DependencyManager) checkTypeChanges(injection *fieldsInjection) error {
+	for _, injected := range injection.injected {
+		previous, found := injection.previousTypes[injected.path]
+		if !found || previous == injected.fieldType {
+			continue
+		}
+		if dm != nil && dm.strictTypeChanges {
+			return fmt.Errorf("field %q type changed %s → %s since last build", injected.path, previous, injected.fieldType)
+		}
+		logger.Warnf("field %q type changed %s → %s since last build", injected.path, previous, injected.fieldType)
+	}
+	return nil
+}
+
+// objectFieldTypes are the types of fields containing other fields.
+var objectFieldTypes = []string{"group", "object", "nested"}
+
+// checkObjectCollisions checks that no field path is defined as an object, explicitly or by having child fields,
+// and also as a leaf field, as it would produce conflicting mappings. All the collisions found are reported,
+// with the locations of both definitions.
+func checkObjectCollisions(defs []common.MapStr) error {
+	leaves := make(map[string]string)
+	objects := make(map[string]string)
+	err := collectFieldKinds("", defs, leaves, objects)
+	if err != nil {
+		return err
+	}
+
+	var collisions []string
+	for path, leafLocation := range leaves {
+		if objectLocation, found := objects[path]; found {
+			collisions = append(collisions, fmt.Sprintf("%q is a leaf field in %s and an object in %s", path, leafLocation, objectLocation))
+		}
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return fmt.Errorf("fields defined both as objects and as leaf fields: %s", strings.Join(collisions, "; "))
+	}
+	return nil
+}
+
+// collectFieldKinds collects the locations of the leaf fields and of the objects defined by the given
+// definitions and their child fields, by path. Dotted names and child fields define their parents as objects.
+func collectFieldKinds(root string, defs []common.MapStr, leaves, objects map[string]string) error {
+	for i, def := range defs {
+		name, ok := def["name"].(string)
+		if !ok {
+			continue
+		}
+		fieldPath := buildFieldPath(root, def)
+		location := fmt.Sprintf("%q (entry %d%s)", name, i+1, enclosingGroups(root))
+
+		for parent := fieldPath; strings.Contains(parent, "."); {
+			parent = parent[:strings.LastIndex(parent, ".")]
+			if parent == root {
+				break
+			}
+			if _, found := objects[parent]; !found {
+				objects[parent] = location
+			}
+		}
+
+		fields, hasFields := def["fields"]
+		ttype, _ := def["type"].(string)
+		if !hasFields && !common.StringSliceContains(objectFieldTypes, ttype) {
+			if _, found := leaves[fieldPath]; !found {
+				leaves[fieldPath] = location
+			}
+			continue
+		}
+		if _, found := objects[fieldPath]; !found {
+			objects[fieldPath] = location
+		}
+		if !hasFields {
+			continue
+		}
+		children, err := groupFields(fieldPath, fields)
+		if err != nil {
+			return err
+		}
+		err = collectFieldKinds(fieldPath, children, leaves, objects)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectFieldTypes collects the types of the given definitions and their child fields, by path.
+func collectFieldTypes(root string, defs []common.MapStr, types map[string]string) error {
+	for _, def := range defs {
+		if _, ok := def["name"].(string); !ok {
+			continue
+		}
+		fieldPath := buildFieldPath(root, def)
+		if ttype, ok := def["type"].(string); ok {
+			types[fieldPath] = ttype
+		}
+
+		fields, found := def["fields"]
+		if !found {
+			continue
+		}
+		children, err := groupFields(fieldPath, fields)
+		if err != nil {
+			return err
+		}
+		err = collectFieldTypes(fieldPath, children, types)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inheritedAttributes are the attributes that imported fields inherit from the groups they are defined in.
+var inheritedAttributes = []string{"index", "doc_values"}
+
+// injectFieldsWithRoot injects the external fields in the given definitions, placed under the root path.
+// Imported fields inherit the attributes defined in groupDefaults, unless they are defined by the local
+// definition or by the imported one. Local definitions take precedence over imported ones.
+func (dm *DependencyManager) injectFieldsWithRoot(root string, defs []common.MapStr, groupDefaults common.MapStr, injection *fieldsInjection) ([]common.MapStr, bool, error) {
+	var updated []common.MapStr
+	var changed bool
+	for i, def := range defs {
+		fieldPath := buildFieldPath(root, def)
+
+		external, _ := def.GetValue("external")
+		if external != nil {
+			def = dm.applyFieldSettings(fieldPath, def)
+			expanded, ok, err := dm.expandWildcardImport(root, fieldPath, def)
+			if err != nil {
+				return nil, false, errors.Wrapf(err, "can't expand wildcard import at %s%s", fieldPath, enclosingGroups(root))
+			}
+			if ok {
+				expandedFields, _, err := dm.injectFieldsWithRoot(root, expanded, groupDefaults, injection)
+				if err != nil {
+					return nil, false, err
+				}
+				updated = append(updated, expandedFields...)
+				changed = true
+				continue
+			}
+		}
+		if external != nil {
+			imported, err := dm.ImportField(external.(string), fieldPath)
+			if err != nil {
+				return nil, false, errors.Wrapf(err, "can't import field at %s%s", fieldPath, enclosingGroups(root))
+			}
+
+			transformed := dm.transformImportedFieldCached(external.(string), fieldPath, imported)
+
+			// Allow overrides of everything, except the imported type, for consistency.
+			transformed.DeepUpdate(def)
+			transformed.Delete("external")
+			err = addNormalize(transformed)
+			if err != nil {
+				return nil, false, errors.Wrapf(err, "invalid definition of field %q%s", fieldPath, enclosingGroups(root))
+			}
+			clearEmptyNormalize(transformed)
+			err = removeMultiFields(transformed)
+			if err != nil {
+				return nil, false, errors.Wrapf(err, "can't remove multi-fields of %s%s", fieldPath, enclosingGroups(root))
+			}
+			err = keepMultiFields(transformed)
+			if err != nil {
+				return nil, false, errors.Wrapf(err, "can't remove multi-fields of %s%s", fieldPath, enclosingGroups(root))
+			}
+			for k, v := range groupDefaults {
+				if _, found := transformed[k]; !found {
+					transformed[k] = v
+				}
+			}
+
+			keepImportedType, err := keepImportedTypeDirectiveValue(transformed)
+			if err != nil {
+				return nil, false, errors.Wrapf(err, "invalid definition of field %q%s", fieldPath, enclosingGroups(root))
+			}
+
+			// Allow to override the type only with compatible types. Overriding from keyword to
+			// constant_keyword is expected, to support the case of setting the value already in the mappings,
+			// unless the settings of the field ask to keep the imported type.
+			ttype, _ := transformed["type"].(string)
+			switch {
+			case ttype == "" || ttype == imported.Type:
+				transformed["type"] = imported.Type
+			case keepImportedType:
+				logger.Warnf("field %q declares type %q, but the imported type %q is kept (%s is set)", fieldPath, ttype, imported.Type, keepImportedTypeDirective)
+				transformed["type"] = imported.Type
+			case ttype == "constant_keyword" && imported.Type == "keyword":
+			case compatibleTypes(imported.Type, ttype):
+				logger.Warnf("field %q declares type %q, compatible with the imported type %q", fieldPath, ttype, imported.Type)
+			case dm.strictTypes:
+				return nil, false, fmt.Errorf("field %q declares type %q, incompatible with the imported type %q%s", fieldPath, ttype, imported.Type, enclosingGroups(root))
+			default:
+				logger.Warnf("field %q declares type %q, but the imported type %q is enforced", fieldPath, ttype, imported.Type)
+				transformed["type"] = imported.Type
+			}
+
+			// Contents of disabled objects are not mapped, so their child fields are not imported.
+			if enabled, ok := transformed["enabled"].(bool); ok && !enabled {
+				_, hasFields := transformed["fields"]
+				_, hasInclude := transformed[includeFieldsDirective]
+				if hasFields || hasInclude {
+					logger.Warnf("field %q is disabled, its child fields are ignored", fieldPath)
+				}
+				delete(transformed, "fields")
+				delete(transformed, includeFieldsDirective)
+			}
+
+			if include, found := transformed[includeFieldsDirective]; found {
+				delete(transformed, includeFieldsDirective)
+				included, err := dm.injectIncludedFields(fieldPath, external.(string), imported, include, inheritGroupDefaults(groupDefaults, transformed), injection)
+				if err != nil {
+					return nil, false, errors.Wrapf(err, "can't include fields of %s%s", fieldPath, enclosingGroups(root))
+				}
+				transformed["fields"] = included
+			}
+
+			err = checkAttributeCombinations(transformed)
+			if err != nil {
+				return nil, false, errors.Wrapf(err, "invalid definition of field %q%s", fieldPath, enclosingGroups(root))
+			}
+			err = checkNarrowedValues(imported, dm.fieldSettings[fieldPath].ExpectedValues)
+			if err != nil {
+				return nil, false, errors.Wrapf(err, "invalid definition of field %q%s", fieldPath, enclosingGroups(root))
+			}
+
+			injection.injected = append(injection.injected, injectedField{
+				path:      fieldPath,
+				fieldType: transformed["type"].(string),
+				source:    external.(string),
+			})
+
+			def = transformed
+			changed = true
+		} else {
+			fields, _ := def.GetValue("fields")
+			if fields != nil {
+				fieldsMs, err := groupFields(fieldPath, fields)
+				if err != nil {
+					return nil, false, err
+				}
+				updatedFields, fieldsChanged, err := dm.injectFieldsWithRoot(fieldPath, fieldsMs, inheritGroupDefaults(groupDefaults, def), injection)
+				if err != nil {
+					return nil, false, err
+				}
+
+				if fieldsChanged {
+					changed = true
+				}
+
+				def.Put("fields", updatedFields)
+			}
+		}
+
+		if skipField(def) {
+			continue
+		}
+		if external != nil && injection.externals != nil {
+			name, _ := defs[i]["name"].(string)
+			occurrence := fmt.Sprintf("%q (entry %d%s)", name, i+1, enclosingGroups(root))
+			if previous, found := injection.externals[fieldPath]; found {
+				if dm.strictDuplicates {
+					return nil, false, fmt.Errorf("field %q is imported more than once, as %s and as %s", fieldPath, previous.occurrence, occurrence)
+				}
+				logger.Warnf("field %q is imported more than once, definition %s overrides definition %s", fieldPath, occurrence, previous.occurrence)
+				name := previous.def["name"]
+				previous.def.DeepUpdate(def)
+				previous.def["name"] = name
+
+				last := injection.injected[len(injection.injected)-1]
+				injection.injected = injection.injected[:len(injection.injected)-1]
+				injection.injected[previous.injectedIndex].fieldType = last.fieldType
+				continue
+			}
+			injection.externals[fieldPath] = &injectedExternal{
+				def:           def,
+				occurrence:    occurrence,
+				injectedIndex: len(injection.injected) - 1,
+			}
+		}
+		updated = append(updated, def)
+	}
+	return updated, changed, nil
+}
+
+// typeFamilies contains groups of field types that are compatible between them, so a field imported
+// with one of them can be declared locally with another one.
+var typeFamilies = [][]string{
+	{"keyword", "constant_keyword", "wildcard"},
+	{"text", "match_only_text"},
+	{"long", "integer", "short", "byte"},
+	{"double", "float", "half_float", "scaled_float"},
+}
+
+// numericTypes are the numeric field types.
+var numericTypes = []string{"long", "integer", "short", "byte", "unsigned_long", "double", "float", "half_float", "scaled_float"}
+
+// metricOverrideTypes are the types that imported numeric fields can be declared with, as done in
+// metric data streams to store pre-aggregated values.
+var metricOverrideTypes = []string{"scaled_float", "histogram", "aggregate_metric_double"}
+
+// compatibleTypes checks if a field imported with the given type can be declared with another one.
+func compatibleTypes(importedType, declaredType string) bool {
+	if common.StringSliceContains(numericTypes, importedType) && common.StringSliceContains(metricOverrideTypes, declaredType) {
+		return true
+	}
+	for _, family := range typeFamilies {
+		if common.StringSliceContains(family, importedType) && common.StringSliceContains(family, declaredType) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNarrowedValues checks that the values an injected field is expected to have, as defined in the
+// expected_values setting of the field, are allowed by the imported definition.
+func checkNarrowedValues(imported FieldDefinition, expectedValues []string) error {
+	if len(imported.AllowedValues) == 0 {
+		return nil
+	}
+	for _, value := range expectedValues {
+		if !imported.AllowedValues.IsAllowed(value) {
+			return fmt.Errorf("value %q in expected_values is not allowed by the imported definition (allowed values: %s)", value, strings.Join(imported.AllowedValues.Values(), ", "))
+		}
+	}
+	return nil
+}
+
+// textTypes are the field types that don't support doc values.
+var textTypes = []string{"text", "match_only_text"}
+
+// checkAttributeCombinations checks that an injected field, including overrides and group defaults, doesn't
+// combine attributes that are rejected when installing the mappings.
+func checkAttributeCombinations(def common.MapStr) error {
+	ttype, _ := def["type"].(string)
+	enabled, hasEnabled := def["enabled"].(bool)
+	index, _ := def["index"].(bool)
+	docValues, _ := def["doc_values"].(bool)
+
+	switch {
+	case hasEnabled && ttype != "object" && ttype != "group":
+		return fmt.Errorf("enabled can only be set in objects, found in field of type %q", ttype)
+	case hasEnabled && !enabled && index:
+		return errors.New("disabled objects can't be indexed (enabled: false, index: true)")
+	case hasEnabled && !enabled && docValues:
+		return errors.New("disabled objects can't have doc values (enabled: false, doc_values: true)")
+	case docValues && common.StringSliceContains(textTypes, ttype):
+		return fmt.Errorf("fields of type %q don't support doc values (doc_values: true)", ttype)
+	}
+	return nil
+}
+
+// addNormalizeDirective is the setting of imported fields listing normalizations added to the ones of the
+// imported field, instead of replacing them.
+const addNormalizeDirective = "normalize_add"
+
+// addNormalize appends to the normalizations of an injected field the ones listed in its normalize_add
+// setting that are not included yet, and removes the setting.
+func addNormalize(field common.MapStr) error {
+	v, found := field[addNormalizeDirective]
+	if !found {
+		return nil
+	}
+	delete(field, addNormalizeDirective)
+
+	added, err := directiveNames(addNormalizeDirective, v)
 	if err != nil {
-		return nil, errors.Wrap(err, "can't build fields schema")
+		return err
+	}
+
+	var normalize []string
+	if current, found := field["normalize"]; found && current != nil {
+		names, err := directiveNames("normalize", current)
+		if err != nil {
+			return err
+		}
+		// Copy the normalizations, they can be shared with the cached imported field.
+		normalize = append(normalize, names...)
+	}
+	for _, name := range added {
+		if !common.StringSliceContains(normalize, name) {
+			normalize = append(normalize, name)
+		}
 	}
-	return &DependencyManager{
-		schema: schema,
-	}, nil
+	field["normalize"] = normalize
+	return nil
 }
 
-func buildFieldsSchema(deps buildmanifest.Dependencies) (map[string][]FieldDefinition, error) {
-	schema := map[string][]FieldDefinition{}
-	ecsSchema, err := loadECSFieldsSchema(deps.ECS)
-	if err != nil {
-		return nil, errors.Wrap(err, "can't load fields")
+// clearEmptyNormalize removes the normalize attribute of an injected field if the local definition
+// overrides it with an empty value, to clear the normalizations of the imported field.
+func clearEmptyNormalize(field common.MapStr) {
+	normalize, found := field["normalize"]
+	if !found {
+		return
+	}
+	switch normalize := normalize.(type) {
+	case nil:
+		delete(field, "normalize")
+	case []interface{}:
+		if len(normalize) == 0 {
+			delete(field, "normalize")
+		}
+	case []string:
+		if len(normalize) == 0 {
+			delete(field, "normalize")
+		}
 	}
-	schema[ecsSchemaName] = ecsSchema
-	return schema, nil
 }
 
-func loadECSFieldsSchema(dep buildmanifest.ECSDependency) ([]FieldDefinition, error) {
-	if dep.Reference == "" {
-		logger.Debugf("ECS dependency isn't defined")
-		return nil, nil
+// keepImportedTypeDirective is the setting of imported fields disabling the override of their type, also with
+// compatible types, so the imported type is always enforced.
+const keepImportedTypeDirective = "keep_imported_type"
+
+// keepImportedTypeDirectiveValue returns the value of the keep_imported_type setting of an injected field,
+// and removes it.
+func keepImportedTypeDirectiveValue(field common.MapStr) (bool, error) {
+	v, found := field[keepImportedTypeDirective]
+	if !found {
+		return false, nil
 	}
+	delete(field, keepImportedTypeDirective)
 
-	content, err := readECSFieldsSchemaFile(dep)
-	if err != nil {
-		return nil, errors.Wrap(err, "error reading ECS fields schema file")
+	keep, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s must be a boolean, found %s", keepImportedTypeDirective, describeValue(v))
+	}
+	return keep, nil
+}
+
+// keepMultiFieldsDirective is the setting of imported fields that, set to false, removes all their multi-fields.
+const keepMultiFieldsDirective = "keep_multi_fields"
+
+// keepMultiFields removes all the multi-fields of an injected field if its keep_multi_fields setting
+// is false, and removes the setting.
+func keepMultiFields(field common.MapStr) error {
+	v, found := field[keepMultiFieldsDirective]
+	if !found {
+		return nil
 	}
+	delete(field, keepMultiFieldsDirective)
 
-	return parseECSFieldsSchema(content)
+	keep, ok := v.(bool)
+	if !ok {
+		return fmt.Errorf("%s must be a boolean, found %s", keepMultiFieldsDirective, describeValue(v))
+	}
+	if !keep {
+		delete(field, "multi_fields")
+	}
+	return nil
 }
 
-func readECSFieldsSchemaFile(dep buildmanifest.ECSDependency) ([]byte, error) {
-	gitReference, err := asGitReference(dep.Reference)
-	if err != nil {
-		return nil, errors.Wrap(err, "can't process the value as Git reference")
+// removeMultiFieldsDirective is the directive listing the imported multi-fields to remove.
+const removeMultiFieldsDirective = "remove_multi_fields"
+
+// removeMultiFields removes from an injected field the multi-fields listed in its remove_multi_fields
+// attribute, and the attribute itself.
+func removeMultiFields(field common.MapStr) error {
+	names, found := field[removeMultiFieldsDirective]
+	if !found {
+		return nil
 	}
+	delete(field, removeMultiFieldsDirective)
 
-	loc, err := locations.NewLocationManager()
+	removed, err := directiveNames(removeMultiFieldsDirective, names)
 	if err != nil {
-		return nil, errors.Wrap(err, "error fetching profile path")
+		return err
 	}
-	cachedSchemaPath := filepath.Join(loc.FieldsCacheDir(), ecsSchemaName, gitReference, ecsSchemaFile)
-	content, err := os.ReadFile(cachedSchemaPath)
-	if errors.Is(err, os.ErrNotExist) {
-		logger.Debugf("Pulling ECS dependency using reference: %s", dep.Reference)
 
-		url := fmt.Sprintf(ecsSchemaURL, gitReference, ecsSchemaFile)
-		logger.Debugf("Schema URL: %s", url)
-		resp, err := http.Get(url)
-		if err != nil {
-			return nil, errors.Wrapf(err, "can't download the online schema (URL: %s)", url)
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode == http.StatusNotFound {
-			return nil, fmt.Errorf("unsatisfied ECS dependency, reference defined in build manifest doesn't exist (HTTP StatusNotFound, URL: %s)", url)
-		} else if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("unexpected HTTP status code: %d", resp.StatusCode)
+	multiFields, found := field["multi_fields"]
+	if !found {
+		return nil
+	}
+	multiFieldsMs, err := toMapStrSlice(multiFields)
+	if err != nil {
+		return errors.Wrap(err, "can't convert multi-fields")
+	}
+	var kept []common.MapStr
+	for _, mf := range multiFieldsMs {
+		if name, _ := mf["name"].(string); !common.StringSliceContains(removed, name) {
+			kept = append(kept, mf)
 		}
+	}
+	if len(kept) == 0 {
+		delete(field, "multi_fields")
+		return nil
+	}
+	field["multi_fields"] = kept
+	return nil
+}
 
-		content, err = io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, errors.Wrapf(err, "can't read schema content (URL: %s)", url)
-		}
-		logger.Debugf("Downloaded %d bytes", len(content))
+// maxDepthDirective is the setting of wildcard imports limiting the depth of the imported fields, in path segments
+// below the prefix.
+const maxDepthDirective = "max_depth"
 
-		cachedSchemaDir := filepath.Dir(cachedSchemaPath)
-		err = os.MkdirAll(cachedSchemaDir, 0755)
-		if err != nil {
-			return nil, errors.Wrapf(err, "can't create cache directories for schema (path: %s)", cachedSchemaDir)
+// expandWildcardImport expands a local definition importing all the fields under a prefix, like "http.*", into
+// definitions importing each of the leaf fields found in the schema, with the same local attributes. Fields are
+// imported up to the depth set in the max_depth setting, if any. Disabled objects are imported instead of their child
+// fields, as their contents are not mapped. It returns false if the definition isn't a wildcard import, what includes
+// definitions whose name is defined as is in the schema.
+func (dm *DependencyManager) expandWildcardImport(root, fieldPath string, def common.MapStr) ([]common.MapStr, bool, error) {
+	prefix := strings.TrimSuffix(fieldPath, ".*")
+	if prefix == fieldPath || prefix == "" {
+		if _, found := def[maxDepthDirective]; found {
+			return nil, false, fmt.Errorf("%s can only be used in wildcard imports (like \"http.*\")", maxDepthDirective)
 		}
+		return nil, false, nil
+	}
+	schemaName, _ := def["external"].(string)
+	schema, idx, ok, err := dm.getSchema(schemaName)
+	if err != nil || !ok || findDefinition(fieldPath, schema, idx) != nil {
+		// Let the import report any error.
+		return nil, false, nil
+	}
 
-		logger.Debugf("Cache downloaded schema: %s", cachedSchemaPath)
-		err = os.WriteFile(cachedSchemaPath, content, 0644)
-		if err != nil {
-			return nil, errors.Wrapf(err, "can't write cached schema (path: %s)", cachedSchemaPath)
+	maxDepth := -1
+	if v, found := def[maxDepthDirective]; found {
+		depth, ok := v.(int)
+		if !ok || depth < 1 {
+			return nil, false, fmt.Errorf("%s must be a positive integer, found %v", maxDepthDirective, v)
 		}
-	} else if err != nil {
-		return nil, errors.Wrapf(err, "can't read cached schema (path: %s)", cachedSchemaPath)
+		maxDepth = depth
 	}
 
-	return content, nil
-}
-
-func parseECSFieldsSchema(content []byte) ([]FieldDefinition, error) {
-	var fields FieldDefinitions
-	err := yaml.Unmarshal(content, &fields)
+	paths, err := dm.ListFields(schemaName)
 	if err != nil {
-		return nil, errors.Wrap(err, "unmarshalling field body failed")
+		return nil, false, err
 	}
+	var expanded []common.MapStr
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if !strings.HasPrefix(path, prefix+".") {
+			continue
+		}
+		path, ok := enabledPath(prefix, path, schema, idx)
+		if !ok || seen[path] {
+			continue
+		}
+		seen[path] = true
+		rel := strings.TrimPrefix(path, prefix+".")
+		if maxDepth > 0 && strings.Count(rel, ".")+1 > maxDepth {
+			continue
+		}
 
-	return fields, nil
-}
-
-func asGitReference(reference string) (string, error) {
-	if !strings.HasPrefix(reference, gitReferencePrefix) {
-		return "", errors.New(`invalid Git reference ("git@" prefix expected)`)
+		field := make(common.MapStr, len(def))
+		for k, v := range def {
+			if k != maxDepthDirective {
+				field[k] = deepCopyValue(v)
+			}
+		}
+		field["name"] = path
+		if root != "" {
+			field["name"] = strings.TrimPrefix(path, root+".")
+		}
+		expanded = append(expanded, field)
+	}
+	if len(expanded) == 0 {
+		return nil, false, fmt.Errorf("no fields found under %q in schema \"%s\"", prefix, schemaName)
 	}
-	return reference[len(gitReferencePrefix):], nil
+	logger.Debugf("Wildcard import %q expanded to %d fields", fieldPath, len(expanded))
+	return expanded, true, nil
 }
 
-// InjectFields function replaces external field references with target definitions.
-func (dm *DependencyManager) InjectFields(defs []common.MapStr) ([]common.MapStr, bool, error) {
-	return dm.injectFieldsWithRoot("", defs)
+// enabledPath returns the path of the field to import for a leaf field under the prefix of a wildcard import. This
+// is the path of its outermost disabled parent object below the prefix, if any, as the contents of disabled objects
+// are not mapped. It returns false if the prefix itself is disabled, or is under a disabled object.
+func enabledPath(prefix, path string, schema []FieldDefinition, idx *schemaIndex) (string, bool) {
+	segments := strings.Split(path, ".")
+	for i := 1; i < len(segments); i++ {
+		parent := strings.Join(segments[:i], ".")
+		def := findDefinition(parent, schema, idx)
+		if def == nil || def.Enabled == nil || *def.Enabled {
+			continue
+		}
+		if len(parent) <= len(prefix) {
+			return "", false
+		}
+		return parent, true
+	}
+	return path, true
 }
 
-func (dm *DependencyManager) injectFieldsWithRoot(root string, defs []common.MapStr) ([]common.MapStr, bool, error) {
-	var updated []common.MapStr
-	var changed bool
-	for _, def := range defs {
-		fieldPath := buildFieldPath(root, def)
+// includeFieldsDirective is the setting of imported groups listing the child fields to import.
+const includeFieldsDirective = "include_fields"
 
-		external, _ := def.GetValue("external")
-		if external != nil {
-			imported, err := dm.ImportField(external.(string), fieldPath)
-			if err != nil {
-				return nil, false, errors.Wrap(err, "can't import field")
-			}
+// injectIncludedFields imports the children of an imported group listed in its include_fields setting, by name.
+func (dm *DependencyManager) injectIncludedFields(groupPath, schemaName string, group FieldDefinition, include interface{}, groupDefaults common.MapStr, injection *fieldsInjection) ([]common.MapStr, error) {
+	if group.Type != "group" {
+		return nil, fmt.Errorf("%s can only be used with groups, imported field is of type %q", includeFieldsDirective, group.Type)
+	}
+	names, err := directiveNames(includeFieldsDirective, include)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s doesn't include any field", includeFieldsDirective)
+	}
 
-			transformed := transformImportedField(imported)
+	children := make([]common.MapStr, len(names))
+	for i, name := range names {
+		children[i] = common.MapStr{"name": name, "external": schemaName}
+	}
 
-			// Allow overrides of everything, except the imported type, for consistency.
-			transformed.DeepUpdate(def)
-			transformed.Delete("external")
+	// Included fields are part of their group, they are not checked for duplicates.
+	externals := injection.externals
+	injection.externals = nil
+	included, _, err := dm.injectFieldsWithRoot(groupPath, children, groupDefaults, injection)
+	injection.externals = externals
+	return included, err
+}
 
-			// Allow to override the type only from keyword to constant_keyword,
-			// to support the case of setting the value already in the mappings.
-			if ttype, _ := transformed["type"].(string); ttype != "constant_keyword" || imported.Type != "keyword" {
-				transformed["type"] = imported.Type
+// directiveNames returns the list of names defined as value of a directive.
+func directiveNames(directive string, value interface{}) ([]string, error) {
+	switch value := value.(type) {
+	case []string:
+		return value, nil
+	case []interface{}:
+		var names []string
+		for _, name := range value {
+			s, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a list of names, found %v (%T)", directive, name, name)
 			}
+			names = append(names, s)
+		}
+		return names, nil
+	default:
+		return nil, fmt.Errorf("%s must be a list of names, found %v (%T)", directive, value, value)
+	}
+}
 
-			def = transformed
-			changed = true
-		} else {
-			fields, _ := def.GetValue("fields")
-			if fields != nil {
-				fieldsMs, err := common.ToMapStrSlice(fields)
-				if err != nil {
-					return nil, false, errors.Wrap(err, "can't convert fields")
-				}
-				updatedFields, fieldsChanged, err := dm.injectFieldsWithRoot(fieldPath, fieldsMs)
-				if err != nil {
-					return nil, false, err
-				}
-
-				if fieldsChanged {
-					changed = true
-				}
-
-				def.Put("fields", updatedFields)
+// groupFields returns the child definitions of a group, checking that they are a list of mappings.
+func groupFields(groupPath string, fields interface{}) ([]common.MapStr, error) {
+	switch fields := fields.(type) {
+	case []common.MapStr:
+		return fields, nil
+	case []interface{}:
+		defs := make([]common.MapStr, len(fields))
+		for i, field := range fields {
+			switch field := field.(type) {
+			case common.MapStr:
+				defs[i] = field
+			case map[string]interface{}:
+				defs[i] = field
+			default:
+				return nil, fmt.Errorf("invalid field definition #%d in fields of group %q, mapping expected, found %s", i+1, groupPath, describeValue(field))
 			}
 		}
+		return defs, nil
+	default:
+		return nil, fmt.Errorf("invalid fields of group %q, list of field definitions expected, found %s", groupPath, describeValue(fields))
+	}
+}
 
-		if skipField(def) {
-			continue
+// describeValue describes a value found in a fields file, for error messages.
+func describeValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "empty value"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case []interface{}:
+		return "list"
+	case map[string]interface{}, common.MapStr:
+		return "mapping"
+	default:
+		return fmt.Sprintf("%T %v", v, v)
+	}
+}
+
+// enclosingGroups describes the groups enclosing the fields defined under the root path, to locate
+// them in error messages.
+func enclosingGroups(root string) string {
+	if root == "" {
+		return ""
+	}
+	return fmt.Sprintf(" under %s group", root)
+}
+
+// inheritGroupDefaults returns the attributes inherited by fields defined in the given group.
+func inheritGroupDefaults(groupDefaults common.MapStr, group common.MapStr) common.MapStr {
+	defaults := make(common.MapStr, len(groupDefaults))
+	for k, v := range groupDefaults {
+		defaults[k] = v
+	}
+	for _, attr := range inheritedAttributes {
+		if v, found := group[attr]; found {
+			defaults[attr] = v
 		}
-		updated = append(updated, def)
 	}
-	return updated, changed, nil
+	return defaults
 }
 
 // skipField decides if a field should be skipped and not injected in the built fields.
@@ -217,22 +2044,195 @@ func skipField(def common.MapStr) bool {
 }
 
 // ImportField method resolves dependency on a single external field using available schemas.
+// Definitions that are external themselves are resolved transitively.
+// Fields in beta can only be imported if explicitly allowed.
 func (dm *DependencyManager) ImportField(schemaName, fieldPath string) (FieldDefinition, error) {
+	imported, err := dm.importField(schemaName, fieldPath, nil)
+	if err != nil {
+		return FieldDefinition{}, err
+	}
+
+	if imported.Beta != "" {
+		if !dm.allowBeta {
+			return FieldDefinition{}, fmt.Errorf("field %q is in beta and can't be imported unless beta fields are allowed (set \"allow_beta: true\" in the ECS dependency): %s", fieldPath, imported.Beta)
+		}
+		logger.Warnf("importing field %q, which is in beta: %s", fieldPath, imported.Beta)
+	}
+
+	for _, pattern := range imported.AllPatterns() {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return FieldDefinition{}, errors.Wrapf(err, "invalid pattern in imported field %q", fieldPath)
+		}
+	}
+	return imported, nil
+}
+
+func (dm *DependencyManager) importField(schemaName, fieldPath string, chain []string) (FieldDefinition, error) {
 	if dm == nil {
 		return FieldDefinition{}, fmt.Errorf(`importing external field "%s": external fields not allowed because dependencies file "_dev/build/build.yml" is missing`, fieldPath)
 	}
-	schema, ok := dm.schema[schemaName]
+	if schemaName == ecsSchemaName && dm.overrides != nil && len(chain) == 0 {
+		if override := dm.overrides.find(fieldPath); override != nil {
+			logger.Debugf("Field %q resolved with local override", fieldPath)
+			return *override, nil
+		}
+	}
+	schema, idx, ok, err := dm.getSchema(schemaName)
+	if err != nil {
+		return FieldDefinition{}, err
+	}
 	if !ok {
-		return FieldDefinition{}, fmt.Errorf(`schema "%s" is not defined as package depedency`, schemaName)
+		return FieldDefinition{}, fmt.Errorf(`schema "%s" is not defined as package depedency (field: %s)`, schemaName, fieldPath)
+	}
+	if schemaName == ecsSchemaName && schema == nil && idx == nil && dm.deps.ECS.Reference == "" {
+		return FieldDefinition{}, fmt.Errorf(`importing external field "%s": ECS reference is not configured, set "dependencies.ecs.reference" in build manifest "_dev/build/build.yml"`, fieldPath)
+	}
+
+	chain = append(chain, schemaName)
+	if common.StringSliceContains(chain[:len(chain)-1], schemaName) {
+		return FieldDefinition{}, fmt.Errorf("circular external reference detected for field %q: %s", fieldPath, strings.Join(chain, " -> "))
 	}
 
-	imported := FindElementDefinition(fieldPath, schema)
+	imported := findDefinition(fieldPath, schema, idx)
 	if imported == nil {
 		return FieldDefinition{}, fmt.Errorf("field definition not found in schema (name: %s)", fieldPath)
 	}
+	if imported.Type == "alias" && findDefinition(imported.Path, schema, idx) == nil {
+		logger.Warnf("alias field %q points to a field not found in schema \"%s\" (path: %s)", fieldPath, schemaName, imported.Path)
+	}
+
+	if imported.External != "" {
+		resolved, err := dm.importField(imported.External, fieldPath, chain)
+		if err != nil {
+			return FieldDefinition{}, err
+		}
+
+		// Attributes defined by the referencing schema take precedence.
+		resolved.Update(*imported)
+		resolved.External = ""
+		return resolved, nil
+	}
 	return *imported, nil
 }
 
+// findDefinition looks for the definition of a field in the schema, using its index if available.
+func findDefinition(fieldPath string, schema []FieldDefinition, idx *schemaIndex) *FieldDefinition {
+	if idx != nil {
+		return idx.find(fieldPath)
+	}
+	return FindElementDefinition(fieldPath, schema)
+}
+
+// importedFields returns the paths of external fields referenced in the given field definitions
+// (before injection), grouped by schema name. It fails if any of them can't be resolved.
+func (dm *DependencyManager) importedFields(defs []common.MapStr) (map[string][]string, error) {
+	imported := make(map[string][]string)
+	err := dm.collectImportedFields("", defs, imported)
+	if err != nil {
+		return nil, err
+	}
+	for schemaName, paths := range imported {
+		sort.Strings(paths)
+		imported[schemaName] = paths
+	}
+	return imported, nil
+}
+
+func (dm *DependencyManager) collectImportedFields(root string, defs []common.MapStr, imported map[string][]string) error {
+	for _, def := range defs {
+		fieldPath := buildFieldPath(root, def)
+
+		external, _ := def.GetValue("external")
+		if external != nil {
+			def = dm.applyFieldSettings(fieldPath, def)
+			expanded, ok, err := dm.expandWildcardImport(root, fieldPath, def)
+			if err != nil {
+				return errors.Wrap(err, "can't expand wildcard import")
+			}
+			if ok {
+				err = dm.collectImportedFields(root, expanded, imported)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			schemaName := external.(string)
+			_, err = dm.ImportField(schemaName, fieldPath)
+			if err != nil {
+				return errors.Wrap(err, "can't import field")
+			}
+			if !common.StringSliceContains(imported[schemaName], fieldPath) {
+				imported[schemaName] = append(imported[schemaName], fieldPath)
+			}
+			continue
+		}
+
+		fields, _ := def.GetValue("fields")
+		if fields == nil {
+			continue
+		}
+		fieldsMs, err := common.ToMapStrSlice(fields)
+		if err != nil {
+			return errors.Wrap(err, "can't convert fields")
+		}
+		err = dm.collectImportedFields(fieldPath, fieldsMs, imported)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListFields method returns the sorted paths of all leaf fields defined in the given schema.
+func (dm *DependencyManager) ListFields(schemaName string) ([]string, error) {
+	if dm == nil {
+		return nil, errors.New("dependency manager is not available")
+	}
+	schema, idx, ok, err := dm.getSchema(schemaName)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf(`schema "%s" is not defined as package depedency`, schemaName)
+	}
+
+	if schema == nil && idx != nil {
+		return idx.leafPaths(), nil
+	}
+
+	paths := listFieldPaths("", schema, nil)
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func listFieldPaths(root string, defs []FieldDefinition, paths []string) []string {
+	for _, def := range defs {
+		key := strings.TrimLeft(root+"."+def.Name, ".")
+		if len(def.Fields) == 0 {
+			if def.Type != "group" {
+				paths = append(paths, key)
+			}
+			continue
+		}
+		paths = listFieldPaths(key, def.Fields, paths)
+	}
+	return paths
+}
+
+// joinPatterns returns a single pattern matching the values that match any of the given patterns, as fields files
+// only accept one pattern per field.
+func joinPatterns(patterns []string) string {
+	if len(patterns) == 1 {
+		return patterns[0]
+	}
+	groups := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		groups[i] = "(?:" + pattern + ")"
+	}
+	return strings.Join(groups, "|")
+}
+
 func buildFieldPath(root string, field common.MapStr) string {
 	path := root
 	if root != "" {
@@ -244,19 +2244,83 @@ func buildFieldPath(root string, field common.MapStr) string {
 	return path
 }
 
-func transformImportedField(fd FieldDefinition) common.MapStr {
+// transformImportedFieldCached returns the transformed representation of an imported
+// field, reusing previous transformations of the same field. Returned maps are deep
+// copies, so callers can safely update them with local overrides.
+func (dm *DependencyManager) transformImportedFieldCached(schemaName, fieldPath string, fd FieldDefinition) common.MapStr {
+	key := schemaName + ":" + fieldPath
+
+	dm.transformedMutex.Lock()
+	defer dm.transformedMutex.Unlock()
+
+	cached, found := dm.transformed[key]
+	if !found {
+		cached = transformImportedField(fd, transformOptions{
+			nameMetadata: dm.nameMetadata,
+			mode:         dm.importMode,
+		})
+		if dm.specVersion != nil {
+			dropUnsupportedAttributes(fieldPath, cached, dm.specVersion)
+		}
+		if description, found := dm.descriptions[fieldPath]; found {
+			cached["description"] = description
+		}
+		if dm.transformed == nil {
+			dm.transformed = make(map[string]common.MapStr)
+		}
+		dm.transformed[key] = cached
+	}
+	return deepCopyMapStr(cached)
+}
+
+// transformOptions determine the attributes included when transforming imported fields.
+type transformOptions struct {
+	// nameMetadata includes the ECS name metadata attributes.
+	nameMetadata bool
+
+	// mode determines if documentation-only attributes are included.
+	mode ImportMode
+}
+
+// transformImportedField returns the representation of an imported field in fields files.
+func transformImportedField(fd FieldDefinition, opts transformOptions) common.MapStr {
 	m := common.MapStr{
 		"name": fd.Name,
 		"type": fd.Type,
 	}
 
-	// Multi-fields don't have descriptions.
+	// Multi-fields don't have descriptions. The full description is preferred, the short
+	// one is only used when it is the only one available.
 	if fd.Description != "" {
 		m["description"] = fd.Description
+	} else if fd.Short != "" {
+		m["description"] = fd.Short
+	}
+
+	if opts.mode == ImportModeDocs && fd.Example != nil {
+		m["example"] = deepCopyValue(fd.Example)
+	}
+
+	if patterns := fd.AllPatterns(); len(patterns) > 0 {
+		m["pattern"] = joinPatterns(patterns)
+	}
+
+	// The format is kept for date fields, so it doesn't need to be defined again, and nanosecond
+	// precision formats are preserved.
+	if fd.DateFormat != "" && (fd.Type == "date" || fd.Type == "date_nanos") {
+		m["date_format"] = fd.DateFormat
+	}
+
+	if fd.ObjectType != "" {
+		m["object_type"] = fd.ObjectType
+	}
+
+	if fd.ObjectTypeMappingType != "" {
+		m["object_type_mapping_type"] = fd.ObjectTypeMappingType
 	}
 
-	if fd.Pattern != "" {
-		m["pattern"] = fd.Pattern
+	if fd.Type == "alias" && fd.Path != "" {
+		m["path"] = fd.Path
 	}
 
 	if fd.Index != nil {
@@ -267,6 +2331,14 @@ func transformImportedField(fd FieldDefinition) common.MapStr {
 		m["doc_values"] = *fd.DocValues
 	}
 
+	if fd.Enabled != nil {
+		m["enabled"] = *fd.Enabled
+	}
+
+	if fd.Runtime != nil {
+		m["runtime"] = deepCopyValue(fd.Runtime)
+	}
+
 	if len(fd.Normalize) > 0 {
 		m["normalize"] = fd.Normalize
 	}
@@ -274,10 +2346,88 @@ func transformImportedField(fd FieldDefinition) common.MapStr {
 	if len(fd.MultiFields) > 0 {
 		var t []common.MapStr
 		for _, f := range fd.MultiFields {
-			i := transformImportedField(f)
+			i := transformImportedField(f, opts)
 			t = append(t, i)
 		}
 		m.Put("multi_fields", t)
 	}
+
+	if opts.nameMetadata {
+		for _, k := range ecsNameMetadataAttributes {
+			if v, found := fd.Extra[k]; found {
+				m[k] = deepCopyValue(v)
+			}
+		}
+	}
+
+	if opts.mode == ImportModeBuild {
+		for _, k := range docsOnlyAttributes {
+			delete(m, k)
+		}
+	}
 	return m
 }
+
+// attributesUnsupportedSinceSpecVersion contains the attributes of imported fields that are not supported by
+// fields files since some version of the package spec, as defined in fields.spec.yml of package-spec v2.2.0.
+// Before 2.0.0, fields files accept any attribute. Since 2.0.0, they only accept the attributes defined in the
+// spec (elastic/package-spec#420), that don't include the following ones.
+var attributesUnsupportedSinceSpecVersion = map[string]*semver.Version{
+	"date_format": semver2_0_0,
+	"runtime":     semver2_0_0,
+	"flat_name":   semver2_0_0,
+	"dashed_name": semver2_0_0,
+}
+
+// dropUnsupportedAttributes removes from a transformed field the attributes not supported by the given
+// version of the package spec.
+func dropUnsupportedAttributes(fieldPath string, m common.MapStr, specVersion *semver.Version) {
+	for attribute, unsupportedSince := range attributesUnsupportedSinceSpecVersion {
+		if _, found := m[attribute]; !found || specVersion.LessThan(unsupportedSince) {
+			continue
+		}
+		logger.Warnf("attribute %q of imported field %q dropped, it isn't supported since spec version %s (package uses %s)", attribute, fieldPath, unsupportedSince, specVersion)
+		delete(m, attribute)
+	}
+
+	if multiFields, ok := m["multi_fields"].([]common.MapStr); ok {
+		for _, mf := range multiFields {
+			dropUnsupportedAttributes(fieldPath+"."+mf["name"].(string), mf, specVersion)
+		}
+	}
+}
+
+func deepCopyMapStr(m common.MapStr) common.MapStr {
+	c := make(common.MapStr, len(m))
+	for k, v := range m {
+		c[k] = deepCopyValue(v)
+	}
+	return c
+}
+
+func deepCopyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case common.MapStr:
+		return deepCopyMapStr(v)
+	case map[string]interface{}:
+		return map[string]interface{}(deepCopyMapStr(v))
+	case []common.MapStr:
+		c := make([]common.MapStr, len(v))
+		for i, m := range v {
+			c[i] = deepCopyMapStr(m)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, e := range v {
+			c[i] = deepCopyValue(e)
+		}
+		return c
+	case []string:
+		c := make([]string, len(v))
+		copy(c, v)
+		return c
+	default:
+		return v
+	}
+}
@@ -5,11 +5,30 @@
 package fields
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 
 	"github.com/elastic/elastic-package/internal/common"
+	"github.com/elastic/elastic-package/internal/packages/buildmanifest"
+	"github.com/elastic/elastic-package/internal/version"
 )
 
 func TestDependencyManagerInjectExternalFields(t *testing.T) {
@@ -137,6 +156,25 @@ func TestDependencyManagerInjectExternalFields(t *testing.T) {
 			changed: true,
 			valid:   true,
 		},
+		{
+			title: "compatible type override",
+			defs: []common.MapStr{
+				{
+					"name":     "container.id",
+					"external": "test",
+					"type":     "wildcard",
+				},
+			},
+			result: []common.MapStr{
+				{
+					"name":        "container.id",
+					"type":        "wildcard",
+					"description": "Container identifier.",
+				},
+			},
+			changed: true,
+			valid:   true,
+		},
 		{
 			title: "multi fields",
 			defs: []common.MapStr{
@@ -266,6 +304,114 @@ func TestDependencyManagerInjectExternalFields(t *testing.T) {
 			changed: true,
 			valid:   true,
 		},
+		{
+			title: "array field cleared",
+			defs: []common.MapStr{
+				{
+					"name":      "host.ip",
+					"external":  "test",
+					"normalize": []interface{}{},
+				},
+			},
+			result: []common.MapStr{
+				{
+					"name":        "host.ip",
+					"type":        "ip",
+					"description": "Host ip addresses.",
+				},
+			},
+			changed: true,
+			valid:   true,
+		},
+		{
+			title: "array field cleared with null",
+			defs: []common.MapStr{
+				{
+					"name":      "host.ip",
+					"external":  "test",
+					"normalize": nil,
+				},
+			},
+			result: []common.MapStr{
+				{
+					"name":        "host.ip",
+					"type":        "ip",
+					"description": "Host ip addresses.",
+				},
+			},
+			changed: true,
+			valid:   true,
+		},
+		{
+			title: "alias field",
+			defs: []common.MapStr{
+				{
+					"name":     "host.hostname_alias",
+					"external": "test",
+				},
+			},
+			result: []common.MapStr{
+				{
+					"name":        "host.hostname_alias",
+					"type":        "alias",
+					"description": "Alias of the hostname.",
+					"path":        "host.hostname",
+				},
+			},
+			changed: true,
+			valid:   true,
+		},
+		{
+			title: "object field with object type",
+			defs: []common.MapStr{
+				{
+					"name":     "labels",
+					"external": "test",
+				},
+			},
+			result: []common.MapStr{
+				{
+					"name":                     "labels",
+					"type":                     "object",
+					"description":              "Custom key/value pairs.",
+					"object_type":              "keyword",
+					"object_type_mapping_type": "*",
+				},
+			},
+			changed: true,
+			valid:   true,
+		},
+		{
+			title: "object type override",
+			defs: []common.MapStr{
+				{
+					"name":        "labels",
+					"external":    "test",
+					"object_type": "long",
+				},
+			},
+			result: []common.MapStr{
+				{
+					"name":                     "labels",
+					"type":                     "object",
+					"description":              "Custom key/value pairs.",
+					"object_type":              "long",
+					"object_type_mapping_type": "*",
+				},
+			},
+			changed: true,
+			valid:   true,
+		},
+		{
+			title: "external with invalid pattern",
+			defs: []common.MapStr{
+				{
+					"name":     "source.address",
+					"external": "test",
+				},
+			},
+			valid: false,
+		},
 		{
 			title: "unknown field",
 			defs: []common.MapStr{
@@ -342,6 +488,75 @@ func TestDependencyManagerInjectExternalFields(t *testing.T) {
 			valid:   true,
 			changed: true,
 		},
+		{
+			title: "inherit group defaults",
+			defs: []common.MapStr{
+				{
+					"name":       "host",
+					"type":       "group",
+					"doc_values": false,
+					"fields": []interface{}{
+						common.MapStr{
+							"name":     "id",
+							"external": "test",
+						},
+						common.MapStr{
+							"name":       "hostname",
+							"external":   "test",
+							"doc_values": true,
+						},
+					},
+				},
+				{
+					"name":  "event",
+					"type":  "group",
+					"index": true,
+					"fields": []interface{}{
+						common.MapStr{
+							"name":     "original",
+							"external": "test",
+						},
+					},
+				},
+			},
+			result: []common.MapStr{
+				{
+					"name":       "host",
+					"type":       "group",
+					"doc_values": false,
+					"fields": []common.MapStr{
+						{
+							"name":        "id",
+							"description": "Unique host id",
+							"type":        "keyword",
+							"doc_values":  false,
+						},
+						{
+							"name":        "hostname",
+							"description": "Hostname of the host",
+							"type":        "keyword",
+							"doc_values":  true,
+						},
+					},
+				},
+				{
+					"name":  "event",
+					"type":  "group",
+					"index": true,
+					"fields": []common.MapStr{
+						{
+							"name":        "original",
+							"description": "Original event.",
+							"type":        "text",
+							"index":       false,
+							"doc_values":  false,
+						},
+					},
+				},
+			},
+			valid:   true,
+			changed: true,
+		},
 		{
 			title: "keep group for docs but not for fields",
 			defs: []common.MapStr{
@@ -399,6 +614,21 @@ func TestDependencyManagerInjectExternalFields(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name:        "user.name",
+			Description: "Short name or login of the user.",
+			Type:        "keyword",
+			MultiFields: []FieldDefinition{
+				{
+					Name: "text",
+					Type: "match_only_text",
+				},
+				{
+					Name: "caseless",
+					Type: "keyword",
+				},
+			},
+		},
 		{
 			Name:        "event.original",
 			Description: "Original event.",
@@ -437,6 +667,24 @@ func TestDependencyManagerInjectExternalFields(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name:    "source.address",
+			Pattern: "^[A-F0-9",
+			Type:    "keyword",
+		},
+		{
+			Name:                  "labels",
+			Description:           "Custom key/value pairs.",
+			Type:                  "object",
+			ObjectType:            "keyword",
+			ObjectTypeMappingType: "*",
+		},
+		{
+			Name:        "host.hostname_alias",
+			Description: "Alias of the hostname.",
+			Type:        "alias",
+			Path:        "host.hostname",
+		},
 	}}
 	dm := &DependencyManager{schema: schema}
 
@@ -454,3 +702,2275 @@ func TestDependencyManagerInjectExternalFields(t *testing.T) {
 		})
 	}
 }
+
+func TestDependencyManagerInjectFieldsEnforcedTypeWarning(t *testing.T) {
+	var logged bytes.Buffer
+	defaultOutput := log.Writer()
+	log.SetOutput(&logged)
+	defer log.SetOutput(defaultOutput)
+
+	schema := map[string][]FieldDefinition{"test": []FieldDefinition{
+		{Name: "event.dataset", Type: "keyword"},
+		{Name: "host.name", Type: "keyword"},
+		{Name: "process.pid", Type: "long"},
+	}}
+	dm := &DependencyManager{schema: schema}
+
+	result, _, err := dm.InjectFields([]common.MapStr{
+		{"name": "event.dataset", "external": "test", "type": "constant_keyword"},
+		{"name": "host.name", "external": "test"},
+		{"name": "process.pid", "external": "test", "type": "keyword"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "constant_keyword", result[0]["type"])
+	assert.Equal(t, "keyword", result[1]["type"])
+	assert.Equal(t, "long", result[2]["type"])
+
+	assert.Contains(t, logged.String(), `field "process.pid" declares type "keyword", but the imported type "long" is enforced`)
+	assert.NotContains(t, logged.String(), `"event.dataset"`)
+	assert.NotContains(t, logged.String(), `"host.name"`)
+}
+
+func TestDependencyManagerInjectCachedExternalFields(t *testing.T) {
+	schema := map[string][]FieldDefinition{"test": []FieldDefinition{
+		{
+			Name:        "process.command_line",
+			Description: "Full command line that started the process.",
+			Type:        "wildcard",
+			MultiFields: []FieldDefinition{
+				{
+					Name: "text",
+					Type: "match_only_text",
+				},
+			},
+		},
+	}}
+	dm := &DependencyManager{schema: schema}
+
+	first, _, err := dm.InjectFields([]common.MapStr{
+		{
+			"name":        "process.command_line",
+			"external":    "test",
+			"description": "Overridden description.",
+		},
+	})
+	assert.NoError(t, err)
+
+	second, _, err := dm.InjectFields([]common.MapStr{
+		{
+			"name":     "process.command_line",
+			"external": "test",
+		},
+	})
+	assert.NoError(t, err)
+
+	// Overrides applied to the first import must not leak into the cached definition.
+	assert.Equal(t, "Overridden description.", first[0]["description"])
+	assert.Equal(t, "Full command line that started the process.", second[0]["description"])
+
+	first[0]["multi_fields"].([]common.MapStr)[0]["type"] = "text"
+	assert.EqualValues(t, []common.MapStr{{"name": "text", "type": "match_only_text"}}, second[0]["multi_fields"])
+}
+
+func BenchmarkDependencyManagerInjectFields(b *testing.B) {
+	const fieldsCount = 500
+
+	var schema []FieldDefinition
+	var defs []common.MapStr
+	for i := 0; i < fieldsCount; i++ {
+		name := fmt.Sprintf("group%d.field%d", i%20, i)
+		schema = append(schema, FieldDefinition{
+			Name:        name,
+			Description: "Benchmark field.",
+			Type:        "keyword",
+			MultiFields: []FieldDefinition{
+				{Name: "text", Type: "match_only_text"},
+				{Name: "caseless", Type: "keyword", Normalize: []string{"array"}},
+			},
+		})
+		defs = append(defs, common.MapStr{"name": name, "external": "test"})
+	}
+	schemas := map[string][]FieldDefinition{"test": schema}
+
+	inject := func(b *testing.B, dm *DependencyManager) {
+		input := make([]common.MapStr, len(defs))
+		for j, def := range defs {
+			input[j] = common.MapStr{"name": def["name"], "external": def["external"]}
+		}
+		_, _, err := dm.InjectFields(input)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			// A new manager doesn't have transformed fields yet.
+			inject(b, &DependencyManager{schema: schemas})
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		dm := &DependencyManager{schema: schemas}
+		inject(b, dm)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			inject(b, dm)
+		}
+	})
+}
+
+const testECSSchema = `
+container:
+  name: container
+  type: group
+  fields:
+    container.id:
+      name: id
+      description: Unique container id.
+      type: keyword
+`
+
+func TestCreateFieldDependencyManagerFromCustomURL(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		if r.URL.Path != "/ecs/v8.0.0/ecs_nested.yml" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, testECSSchema)
+	}))
+	defer server.Close()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+	dm, err := CreateFieldDependencyManager(context.Background(), deps)
+	require.NoError(t, err)
+
+	imported, err := dm.ImportField("ecs", "container.id")
+	require.NoError(t, err)
+	assert.Equal(t, "keyword", imported.Type)
+
+	// Second manager must be built from the cached schema.
+	dm, err = CreateFieldDependencyManager(context.Background(), deps)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/ecs/v8.0.0/ecs_nested.yml"}, requested)
+
+	imported, err = dm.ImportField("ecs", "container.id")
+	require.NoError(t, err)
+	assert.Equal(t, "keyword", imported.Type)
+
+	t.Setenv(forceSchemaRefreshEnv, "true")
+	_, err = CreateFieldDependencyManager(context.Background(), deps)
+	require.NoError(t, err)
+	assert.Len(t, requested, 2, "schema must be downloaded again when refresh is forced")
+
+	deps.ECS.Reference = "git@v0.0.0"
+	_, err = CreateFieldDependencyManager(context.Background(), deps)
+	assert.Error(t, err)
+}
+
+func TestProgressReader(t *testing.T) {
+	content := bytes.Repeat([]byte("a"), 3*downloadProgressInterval+1)
+
+	r := newProgressReader(bytes.NewReader(content), int64(len(content)))
+	read, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, content, read)
+	assert.Equal(t, int64(len(content)), r.read)
+	assert.Greater(t, r.reported, int64(0))
+	assert.Less(t, r.read-r.reported, int64(downloadProgressInterval))
+}
+
+func TestCreateFieldDependencyManagerFromSharedCache(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		fmt.Fprint(w, testECSSchema)
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv("ELASTIC_PACKAGE_FIELDS_CACHE_DIR", cacheDir)
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	firstSharedDir := t.TempDir()
+	secondSharedDir := t.TempDir()
+	require.NoError(t, writeCachedSchema(filepath.Join(firstSharedDir, "ecs", "v8.0.0", "ecs_nested.yml"), []byte(testECSSchema)))
+	plainSchema := filepath.Join(secondSharedDir, "ecs", "v8.1.0", "ecs_nested.yml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(plainSchema), 0755))
+	require.NoError(t, os.WriteFile(plainSchema, []byte(strings.ReplaceAll(testECSSchema, "type: keyword", "type: wildcard")), 0644))
+	t.Setenv(sharedFieldsCacheDirsEnv, strings.Join([]string{firstSharedDir, "", secondSharedDir}, string(filepath.ListSeparator)))
+
+	cases := []struct {
+		reference string
+		expected  string
+	}{
+		{"git@v8.0.0", "keyword"},
+		{"git@v8.1.0", "wildcard"},
+		{"git@v8.2.0", "keyword"},
+	}
+	for _, c := range cases {
+		dm, err := CreateFieldDependencyManager(context.Background(), buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: c.reference}})
+		require.NoError(t, err)
+		imported, err := dm.ImportField("ecs", "container.id")
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, imported.Type, c.reference)
+	}
+
+	// Only schemas not found in shared caches are downloaded, and written to the fields cache.
+	assert.Equal(t, []string{"/ecs/v8.2.0/ecs_nested.yml"}, requested)
+	cached, err := filepath.Glob(filepath.Join(cacheDir, "ecs", "*"))
+	require.NoError(t, err)
+	if assert.Len(t, cached, 1) {
+		assert.Equal(t, "v8.2.0", filepath.Base(cached[0]))
+	}
+	assert.NoDirExists(t, filepath.Join(firstSharedDir, "ecs", "v8.2.0"))
+}
+
+func TestCreateFieldDependencyManagerWithGitHubToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "":
+			w.WriteHeader(http.StatusUnauthorized)
+		case "token secret":
+			fmt.Fprint(w, testECSSchema)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+	githubTokenHosts["127.0.0.1"] = true
+	defer delete(githubTokenHosts, "127.0.0.1")
+
+	cases := []struct {
+		title         string
+		token         string
+		prefixedToken string
+		expectedError string
+	}{
+		{title: "no token", expectedError: "authentication required"},
+		{title: "wrong token", token: "wrong", expectedError: "authentication failed"},
+		{title: "token", token: "secret"},
+		{title: "prefixed token", token: "wrong", prefixedToken: "secret"},
+	}
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+			t.Setenv("GITHUB_TOKEN", c.token)
+			t.Setenv("ELASTIC_PACKAGE_GITHUB_TOKEN", c.prefixedToken)
+
+			_, err := CreateFieldDependencyManager(context.Background(), deps)
+			if c.expectedError != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), c.expectedError)
+					assert.NotContains(t, err.Error(), "secret")
+				}
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	t.Run("token not sent to other hosts", func(t *testing.T) {
+		delete(githubTokenHosts, "127.0.0.1")
+		t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+		t.Setenv("GITHUB_TOKEN", "secret")
+
+		_, err := CreateFieldDependencyManager(context.Background(), deps)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "authentication required")
+		}
+	})
+}
+
+func TestECSSchemaURLTemplate(t *testing.T) {
+	t.Setenv(ecsSchemaURLEnv, "")
+	for _, reference := range []string{"v8.11.0", "8.11", "1.0", "main", "0b8b7d6"} {
+		urlTemplates, err := ecsSchemaURLTemplates(reference, false)
+		require.NoError(t, err, reference)
+		assert.Equal(t, []string{"https://raw.githubusercontent.com/elastic/ecs/%s/generated/ecs/%s"}, urlTemplates, reference)
+	}
+
+	for _, reference := range []string{"v0.1.0", "1.0.0-beta2"} {
+		_, err := ecsSchemaURLTemplates(reference, false)
+		if assert.Error(t, err, reference) {
+			assert.Contains(t, err.Error(), "predates the generated schema files")
+		}
+	}
+
+	t.Setenv(ecsSchemaURLEnv, "https://mirror.example.com/ecs/%s/%s, https://raw.githubusercontent.com/elastic/ecs/%s/generated/ecs/%s")
+	urlTemplates, err := ecsSchemaURLTemplates("v8.11.0", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"https://mirror.example.com/ecs/%s/%s",
+		"https://raw.githubusercontent.com/elastic/ecs/%s/generated/ecs/%s",
+	}, urlTemplates)
+
+	_, err = ecsSchemaURLTemplates("1.0.0-beta2", false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "predates the generated schema files")
+	}
+
+	t.Setenv(ecsSchemaURLEnv, "https://mirror.example.com/ecs/%s")
+	_, err = ecsSchemaURLTemplates("v8.11.0", false)
+	assert.Error(t, err)
+}
+
+func TestCreateFieldDependencyManagerExperimentalSchema(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		switch r.URL.Path {
+		case "/ecs/main/ecs_nested.yml":
+			fmt.Fprint(w, testECSSchema)
+		case "/ecs/main/experimental/ecs_nested.yml":
+			fmt.Fprint(w, strings.Replace(testECSSchema, "type: keyword", "type: wildcard", 1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+	t.Setenv(ecsExperimentalSchemaURLEnv, server.URL+"/ecs/%s/experimental/%s")
+
+	for i := 0; i < 2; i++ {
+		for _, experimental := range []bool{false, true} {
+			deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@main", Experimental: experimental}}
+			dm, err := CreateFieldDependencyManager(context.Background(), deps)
+			require.NoError(t, err)
+			imported, err := dm.ImportField("ecs", "container.id")
+			require.NoError(t, err)
+			if experimental {
+				assert.Equal(t, "wildcard", imported.Type)
+			} else {
+				assert.Equal(t, "keyword", imported.Type)
+			}
+		}
+	}
+	assert.Equal(t, []string{"/ecs/main/ecs_nested.yml", "/ecs/main/experimental/ecs_nested.yml"}, requested, "schema variants must be cached separately")
+
+	t.Setenv(ecsExperimentalSchemaURLEnv, "")
+	urlTemplates, err := ecsSchemaURLTemplates("main", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://raw.githubusercontent.com/elastic/ecs/%s/experimental/generated/ecs/%s"}, urlTemplates)
+}
+
+func TestCreateFieldDependencyManagerETagRevalidation(t *testing.T) {
+	etag := `"v1"`
+	schema := testECSSchema
+	var requests, conditionalRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if match := r.Header.Get("If-None-Match"); match != "" {
+			conditionalRequests++
+			if match == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, schema)
+	}))
+	defer server.Close()
+
+	dataHome := t.TempDir()
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", dataHome)
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	importType := func(reference string) string {
+		deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: reference}}
+		dm, err := CreateFieldDependencyManager(context.Background(), deps)
+		require.NoError(t, err)
+		imported, err := dm.ImportField("ecs", "container.id")
+		require.NoError(t, err)
+		return imported.Type
+	}
+
+	assert.Equal(t, "keyword", importType("git@main"))
+	assert.Equal(t, 1, requests)
+	var cachedSchemaPath string
+	err := filepath.WalkDir(dataHome, func(path string, d fs.DirEntry, err error) error {
+		if strings.HasSuffix(path, etagExt) {
+			cachedSchemaPath = strings.TrimSuffix(path, etagExt)
+		}
+		return err
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, cachedSchemaPath, "ETag must be stored with the cached schema")
+
+	// Recent, the cached schema is used without revalidating it.
+	schema = strings.Replace(testECSSchema, "type: keyword", "type: wildcard", 1)
+	assert.Equal(t, "keyword", importType("git@main"))
+	assert.Equal(t, 1, requests)
+
+	old := time.Now().Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(cachedSchemaPath+compressedSchemaExt, old, old))
+
+	// Not modified, the cached schema is used, and its timestamp refreshed.
+	assert.Equal(t, "keyword", importType("git@main"))
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 1, conditionalRequests)
+	info, err := os.Stat(cachedSchemaPath + compressedSchemaExt)
+	require.NoError(t, err)
+	assert.True(t, info.ModTime().After(old), "timestamp of not modified schema must be refreshed")
+
+	// Modified, the new schema is downloaded and cached.
+	etag = `"v2"`
+	t.Setenv(schemaCacheMaxAgeEnv, "0s")
+	assert.Equal(t, "wildcard", importType("git@main"))
+	assert.Equal(t, 3, requests)
+	assert.Equal(t, 2, conditionalRequests)
+	stored, err := os.ReadFile(cachedSchemaPath + etagExt)
+	require.NoError(t, err)
+	assert.Equal(t, etag, string(stored))
+
+	// Pinned references are not revalidated, nor their ETag stored.
+	assert.Equal(t, "wildcard", importType("git@v8.0.0"))
+	assert.Equal(t, "wildcard", importType("git@v8.0.0"))
+	assert.Equal(t, 4, requests)
+	assert.Equal(t, 2, conditionalRequests)
+}
+
+func TestCreateFieldDependencyManagerCachedSchemasOnly(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, testECSSchema)
+	}))
+	defer server.Close()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@main"}}
+	_, err := CreateFieldDependencyManager(context.Background(), deps, WithCachedSchemasOnly())
+	if assert.Error(t, err) {
+		assert.ErrorIs(t, err, ErrSchemaNotCached)
+	}
+	assert.Equal(t, 0, requests)
+
+	_, err = CreateFieldDependencyManager(context.Background(), deps)
+	require.NoError(t, err)
+	assert.Equal(t, 1, requests)
+
+	// Cached schemas of moving references are not revalidated, even if a refresh is forced.
+	t.Setenv(forceSchemaRefreshEnv, "true")
+	dm, err := CreateFieldDependencyManager(context.Background(), deps, WithCachedSchemasOnly())
+	require.NoError(t, err)
+	imported, err := dm.ImportField("ecs", "container.id")
+	require.NoError(t, err)
+	assert.Equal(t, "keyword", imported.Type)
+	assert.Equal(t, 1, requests)
+}
+
+func TestCreateFieldDependencyManagerWithSchemaHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Route") != "ecs-mirror" || r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, testECSSchema)
+	}))
+	defer server.Close()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+	t.Setenv(ecsSchemaHeadersEnv, "X-Route: ecs-mirror; X-Api-Key: secret")
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+	dm, err := CreateFieldDependencyManager(context.Background(), deps)
+	require.NoError(t, err)
+	_, err = dm.ImportField("ecs", "container.id")
+	require.NoError(t, err)
+
+	t.Setenv(ecsSchemaHeadersEnv, "X-Route")
+	t.Setenv(forceSchemaRefreshEnv, "true")
+	_, err = CreateFieldDependencyManager(context.Background(), deps)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid value of "+ecsSchemaHeadersEnv)
+	}
+}
+
+func TestCreateFieldDependencyManagerUserAgent(t *testing.T) {
+	var userAgents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+		fmt.Fprint(w, testECSSchema)
+	}))
+	defer server.Close()
+
+	defaultTag := version.Tag
+	version.Tag = "v0.99.0"
+	defer func() { version.Tag = defaultTag }()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+	t.Setenv(forceSchemaRefreshEnv, "true")
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+	_, err := CreateFieldDependencyManager(context.Background(), deps)
+	require.NoError(t, err)
+
+	t.Setenv(schemaUserAgentEnv, "ci-builder/1.0")
+	_, err = CreateFieldDependencyManager(context.Background(), deps)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"elastic-package/0.99.0", "ci-builder/1.0"}, userAgents)
+}
+
+func TestCreateFieldDependencyManagerRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/moved/v8.0.0/ecs_nested.yml":
+			http.Redirect(w, r, "/ecs/v8.0.0/ecs_nested.yml", http.StatusMovedPermanently)
+		case r.URL.Path == "/ecs/v8.0.0/ecs_nested.yml":
+			fmt.Fprint(w, testECSSchema)
+		case strings.HasPrefix(r.URL.Path, "/loop/"):
+			n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+			http.Redirect(w, r, fmt.Sprintf("/loop/v8.0.0/ecs_nested.yml?n=%d", n+1), http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/moved/%s/%s")
+	dm, err := CreateFieldDependencyManager(context.Background(), deps)
+	require.NoError(t, err)
+	_, err = dm.ImportField("ecs", "container.id")
+	require.NoError(t, err)
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/loop/%s/%s")
+	_, err = CreateFieldDependencyManager(context.Background(), deps)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), fmt.Sprintf("stopped after %d redirects: %s/loop/v8.0.0/ecs_nested.yml -> %s/loop/v8.0.0/ecs_nested.yml?n=1 ->", maxSchemaRedirects, server.URL, server.URL))
+		assert.Contains(t, err.Error(), fmt.Sprintf("%s/loop/v8.0.0/ecs_nested.yml?n=%d", server.URL, maxSchemaRedirects+1))
+	}
+}
+
+func TestRedactedHeaderValue(t *testing.T) {
+	assert.Equal(t, "ecs-mirror", redactedHeaderValue("X-Route", "ecs-mirror"))
+	assert.Equal(t, "<redacted>", redactedHeaderValue("X-Api-Key", "secret"))
+	assert.Equal(t, "<redacted>", redactedHeaderValue("Proxy-Authorization", "Basic abc"))
+	assert.Equal(t, "<redacted>", redactedHeaderValue("X-Gateway-Token", "abc"))
+}
+
+func TestSchemaCacheStats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testECSSchema)
+	}))
+	defer server.Close()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	before := CurrentSchemaCacheStats()
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+	for i := 0; i < 3; i++ {
+		_, err := CreateFieldDependencyManager(context.Background(), deps)
+		require.NoError(t, err)
+	}
+	after := CurrentSchemaCacheStats()
+
+	assert.Equal(t, uint64(2), after.Hits-before.Hits)
+	assert.Equal(t, uint64(0), after.SharedHits-before.SharedHits)
+	assert.Equal(t, uint64(1), after.Misses-before.Misses)
+	assert.Equal(t, uint64(1), after.Downloads-before.Downloads)
+	assert.Equal(t, uint64(len(testECSSchema)), after.DownloadedBytes-before.DownloadedBytes)
+}
+
+func TestCreateFieldDependencyManagerMirrorFallback(t *testing.T) {
+	var requested []string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, "mirror"+r.URL.Path)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mirror.Close()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, "upstream"+r.URL.Path)
+		if r.URL.Path != "/ecs/v8.0.0/ecs_nested.yml" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, testECSSchema)
+	}))
+	defer upstream.Close()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, mirror.URL+"/ecs/%s/%s,"+upstream.URL+"/ecs/%s/%s")
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+	dm, err := CreateFieldDependencyManager(context.Background(), deps)
+	require.NoError(t, err)
+	_, err = dm.ImportField("ecs", "container.id")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mirror/ecs/v8.0.0/ecs_nested.yml", "upstream/ecs/v8.0.0/ecs_nested.yml"}, requested)
+
+	// The downloaded schema is cached.
+	requested = nil
+	_, err = CreateFieldDependencyManager(context.Background(), deps)
+	require.NoError(t, err)
+	assert.Empty(t, requested)
+
+	t.Setenv(ecsSchemaURLEnv, upstream.URL+"/other/%s/%s,"+upstream.URL+"/ecs/%s/%s")
+	deps = buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v9.9.9"}}
+	_, err = CreateFieldDependencyManager(context.Background(), deps)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "reference defined in build manifest doesn't exist")
+		assert.Contains(t, err.Error(), upstream.URL+"/other/v9.9.9/ecs_nested.yml, "+upstream.URL+"/ecs/v9.9.9/ecs_nested.yml")
+	}
+
+	t.Setenv(ecsSchemaURLEnv, mirror.URL+"/ecs/%s/%s,"+upstream.URL+"/ecs/%s/%s")
+	_, err = CreateFieldDependencyManager(context.Background(), deps)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "can't download the online schema (URLs tried: ")
+	}
+}
+
+func TestDependencyManagerImportedFields(t *testing.T) {
+	schema := map[string][]FieldDefinition{"test": []FieldDefinition{
+		{
+			Name: "container.id",
+			Type: "keyword",
+		},
+		{
+			Name: "host",
+			Type: "group",
+			Fields: []FieldDefinition{
+				{
+					Name: "id",
+					Type: "keyword",
+				},
+				{
+					Name: "hostname",
+					Type: "keyword",
+				},
+			},
+		},
+	}}
+	dm := &DependencyManager{schema: schema}
+
+	defs := []common.MapStr{
+		{
+			"name": "host",
+			"type": "group",
+			"fields": []interface{}{
+				common.MapStr{
+					"name":     "id",
+					"external": "test",
+				},
+			},
+		},
+		{
+			"name":     "container.id",
+			"external": "test",
+		},
+		{
+			"name": "message",
+			"type": "text",
+		},
+	}
+
+	imported, err := dm.importedFields(defs)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"test": {"container.id", "host.id"}}, imported)
+
+	all, err := dm.ListFields("test")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"container.id", "host.hostname", "host.id"}, all)
+
+	_, err = dm.importedFields([]common.MapStr{{"name": "container.name", "external": "test"}})
+	assert.Error(t, err)
+
+	_, err = dm.ListFields("unknown")
+	assert.Error(t, err)
+}
+
+func TestDependencyManagerTransitiveExternalFields(t *testing.T) {
+	schema := map[string][]FieldDefinition{
+		"first": []FieldDefinition{
+			{
+				Name:     "host.id",
+				External: "second",
+			},
+			{
+				Name:     "host.name",
+				External: "second",
+			},
+		},
+		"second": []FieldDefinition{
+			{
+				Name:        "host.id",
+				Description: "Unique host id",
+				Type:        "keyword",
+			},
+			{
+				Name:     "host.name",
+				External: "third",
+			},
+		},
+		"third": []FieldDefinition{
+			{
+				Name:     "host.name",
+				External: "first",
+			},
+		},
+	}
+	dm := &DependencyManager{schema: schema}
+
+	imported, err := dm.ImportField("first", "host.id")
+	require.NoError(t, err)
+	assert.Equal(t, FieldDefinition{Name: "host.id", Description: "Unique host id", Type: "keyword"}, imported)
+
+	_, _, err = dm.InjectFields([]common.MapStr{
+		{
+			"name":     "host.name",
+			"external": "first",
+		},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `circular external reference detected for field "host.name": first -> second -> third -> first`)
+	}
+}
+
+func TestCreateFieldDependencyManagerWithDataStreamDependencies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ecs/v8.0.0/ecs_nested.yml":
+			fmt.Fprint(w, testECSSchema)
+		case "/ecs/v8.1.0/ecs_nested.yml":
+			fmt.Fprint(w, strings.ReplaceAll(testECSSchema, "type: keyword", "type: wildcard"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{
+		Reference:   "git@v8.0.0",
+		StrictTypes: true,
+		DataStreams: map[string]string{
+			"newer": "git@v8.1.0",
+			"same":  "",
+		},
+	}}
+	dm, err := CreateFieldDependencyManager(context.Background(), deps, WithStrictTypes())
+	require.NoError(t, err)
+
+	cases := []struct {
+		dataStream string
+		expected   string
+	}{
+		{"", "keyword"},
+		{"older", "keyword"},
+		{"same", "keyword"},
+		{"newer", "wildcard"},
+	}
+	for _, c := range cases {
+		imported, err := dm.ForDataStream(c.dataStream).ImportField("ecs", "container.id")
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, imported.Type, "data stream %q", c.dataStream)
+	}
+
+	// Data streams keep the settings of the package dependency.
+	_, _, err = dm.ForDataStream("newer").InjectFields([]common.MapStr{{"name": "container.id", "external": "ecs", "type": "long"}})
+	assert.Error(t, err)
+}
+
+func TestDependencyManagerUnknownAttributes(t *testing.T) {
+	var schema []FieldDefinition
+	err := yaml.Unmarshal([]byte(`
+- name: service.tier
+  type: keyword
+  owner: platform-team
+  retention:
+    days: 30
+  multi_fields:
+    - name: text
+      type: match_only_text
+      analyzer: simple
+`), &schema)
+	require.NoError(t, err)
+
+	defs := []common.MapStr{{"name": "service.tier", "external": "custom"}}
+	schemas := map[string][]FieldDefinition{"custom": schema, "ecs": schema}
+
+	dm := &DependencyManager{schema: schemas}
+	result, _, err := dm.InjectFields(defs)
+	require.NoError(t, err)
+	assert.Equal(t, []common.MapStr{{
+		"name": "service.tier",
+		"type": "keyword",
+		"multi_fields": []common.MapStr{
+			{"name": "text", "type": "match_only_text"},
+		},
+	}}, result)
+}
+
+func TestDependencyManagerECSNameMetadata(t *testing.T) {
+	var schema FieldDefinitions
+	err := yaml.Unmarshal([]byte(`
+host:
+  name: host
+  type: group
+  fields:
+    os.full:
+      name: os.full
+      type: keyword
+      flat_name: host.os.full
+      dashed_name: host-os-full
+      level: extended
+      multi_fields:
+        - name: text
+          type: match_only_text
+          flat_name: host.os.full.text
+`), &schema)
+	require.NoError(t, err)
+	defs := []common.MapStr{{"name": "host.os.full", "external": "ecs"}}
+
+	dm := &DependencyManager{schema: map[string][]FieldDefinition{"ecs": schema}}
+	result, _, err := dm.InjectFields(defs)
+	require.NoError(t, err)
+	assert.NotContains(t, result[0], "flat_name")
+	assert.NotContains(t, result[0], "dashed_name")
+
+	dm = &DependencyManager{schema: map[string][]FieldDefinition{"ecs": schema}}
+	require.NoError(t, WithECSNameMetadata()(dm))
+	result, _, err = dm.InjectFields(defs)
+	require.NoError(t, err)
+	assert.Equal(t, []common.MapStr{{
+		"name":        "host.os.full",
+		"type":        "keyword",
+		"flat_name":   "host.os.full",
+		"dashed_name": "host-os-full",
+		"multi_fields": []common.MapStr{
+			{"name": "text", "type": "match_only_text", "flat_name": "host.os.full.text"},
+		},
+	}}, result)
+}
+
+func TestCreateFieldDependencyManagerExtraAttributes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `
+host:
+  name: host
+  type: group
+  fields:
+    os.full:
+      name: os.full
+      type: keyword
+      flat_name: host.os.full
+      level: extended
+`)
+	}))
+	defer server.Close()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+	dm, err := CreateFieldDependencyManager(context.Background(), deps)
+	require.NoError(t, err)
+	imported, err := dm.ImportField("ecs", "host.os.full")
+	require.NoError(t, err)
+	assert.Nil(t, imported.Extra, "extra attributes must not be kept by default")
+
+	dm, err = CreateFieldDependencyManager(context.Background(), deps, WithECSNameMetadata())
+	require.NoError(t, err)
+	imported, err = dm.ImportField("ecs", "host.os.full")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"flat_name": "host.os.full", "level": "extended"}, imported.Extra)
+}
+
+func TestDependencyManagerImportRuntimeFields(t *testing.T) {
+	var schema []FieldDefinition
+	err := yaml.Unmarshal([]byte(`
+- name: event.duration
+  type: long
+  runtime: true
+- name: event.duration_ms
+  type: long
+  runtime: "emit(doc['event.duration'].value / 1000000)"
+`), &schema)
+	require.NoError(t, err)
+
+	dm := &DependencyManager{schema: map[string][]FieldDefinition{"test": schema}}
+	result, _, err := dm.InjectFields([]common.MapStr{
+		{"name": "event.duration", "external": "test"},
+		{"name": "event.duration_ms", "external": "test"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []common.MapStr{
+		{"name": "event.duration", "type": "long", "runtime": true},
+		{"name": "event.duration_ms", "type": "long", "runtime": "emit(doc['event.duration'].value / 1000000)"},
+	}, result)
+
+	result, _, err = dm.InjectFields([]common.MapStr{
+		{"name": "event.duration", "external": "test", "runtime": false},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []common.MapStr{
+		{"name": "event.duration", "type": "long", "runtime": false},
+	}, result)
+}
+
+func TestDependencyManagerImportDisabledObjects(t *testing.T) {
+	var schema []FieldDefinition
+	err := yaml.Unmarshal([]byte(`
+- name: process.env_vars
+  type: object
+  enabled: false
+  fields:
+  - name: path
+    type: keyword
+- name: process.args
+  type: keyword
+`), &schema)
+	require.NoError(t, err)
+
+	dm := &DependencyManager{schema: map[string][]FieldDefinition{"test": schema}}
+	for _, def := range []common.MapStr{
+		{"name": "process.env_vars", "external": "test"},
+		{"name": "process.env_vars", "external": "test", "fields": []interface{}{
+			map[string]interface{}{"name": "path", "external": "test"},
+		}},
+	} {
+		result, _, err := dm.InjectFields([]common.MapStr{def})
+		require.NoError(t, err)
+		assert.Equal(t, []common.MapStr{
+			{"name": "process.env_vars", "type": "object", "enabled": false},
+		}, result)
+	}
+
+	dm.fieldSettings = map[string]buildmanifest.FieldSettings{
+		"process.env_vars": {Name: "process.env_vars", IncludeFields: []string{"path"}},
+	}
+	result, _, err := dm.InjectFields([]common.MapStr{{"name": "process.env_vars", "external": "test"}})
+	require.NoError(t, err)
+	assert.Equal(t, []common.MapStr{
+		{"name": "process.env_vars", "type": "object", "enabled": false},
+	}, result)
+}
+
+func TestDependencyManagerInvalidAttributeCombinations(t *testing.T) {
+	var schema []FieldDefinition
+	err := yaml.Unmarshal([]byte(`
+- name: process.env_vars
+  type: object
+  enabled: false
+- name: process.args
+  type: keyword
+- name: message
+  type: match_only_text
+`), &schema)
+	require.NoError(t, err)
+
+	dm := &DependencyManager{schema: map[string][]FieldDefinition{"test": schema}}
+	cases := []struct {
+		title    string
+		def      common.MapStr
+		expected string
+	}{
+		{
+			title:    "disabled object with doc values",
+			def:      common.MapStr{"name": "process.env_vars", "external": "test", "doc_values": true},
+			expected: `invalid definition of field "process.env_vars": disabled objects can't have doc values`,
+		},
+		{
+			title:    "disabled object indexed",
+			def:      common.MapStr{"name": "process.env_vars", "external": "test", "index": true},
+			expected: `invalid definition of field "process.env_vars": disabled objects can't be indexed`,
+		},
+		{
+			title:    "enabled in keyword",
+			def:      common.MapStr{"name": "process.args", "external": "test", "enabled": false},
+			expected: `enabled can only be set in objects, found in field of type "keyword"`,
+		},
+		{
+			title:    "text with doc values",
+			def:      common.MapStr{"name": "message", "external": "test", "doc_values": true},
+			expected: `fields of type "match_only_text" don't support doc values`,
+		},
+		{
+			title: "valid overrides",
+			def:   common.MapStr{"name": "process.args", "external": "test", "index": false, "doc_values": false},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			_, _, err := dm.InjectFields([]common.MapStr{c.def})
+			if c.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), c.expected)
+			}
+		})
+	}
+}
+
+func TestDependencyManagerWildcardImports(t *testing.T) {
+	var schema FieldDefinitions
+	err := yaml.Unmarshal([]byte(`
+http:
+  name: http
+  type: group
+  fields:
+    http.version:
+      name: version
+      type: keyword
+    http.request:
+      name: request
+      type: group
+      fields:
+        http.request.method:
+          name: method
+          type: keyword
+        http.request.body:
+          name: body
+          type: group
+          fields:
+            http.request.body.bytes:
+              name: bytes
+              type: long
+process:
+  name: process
+  type: group
+  fields:
+    process.args:
+      name: args
+      type: keyword
+    process.env_vars:
+      name: env_vars
+      type: object
+      enabled: false
+      fields:
+        process.env_vars.path:
+          name: path
+          type: keyword
+`), &schema)
+	require.NoError(t, err)
+
+	cases := []struct {
+		title    string
+		defs     []common.MapStr
+		settings []buildmanifest.FieldSettings
+		expected []common.MapStr
+		fail     string
+	}{
+		{
+			title: "unlimited depth",
+			defs:  []common.MapStr{{"name": "http.*", "external": "test"}},
+			expected: []common.MapStr{
+				{"name": "http.request.body.bytes", "type": "long"},
+				{"name": "http.request.method", "type": "keyword"},
+				{"name": "http.version", "type": "keyword"},
+			},
+		},
+		{
+			title:    "depth 1",
+			defs:     []common.MapStr{{"name": "http.*", "external": "test"}},
+			settings: []buildmanifest.FieldSettings{{Name: "http.*", MaxDepth: 1}},
+			expected: []common.MapStr{
+				{"name": "http.version", "type": "keyword"},
+			},
+		},
+		{
+			title: "depth 1 in group",
+			defs: []common.MapStr{{"name": "http", "type": "group", "fields": []interface{}{
+				map[string]interface{}{"name": "request.*", "external": "test", "index": false},
+			}}},
+			settings: []buildmanifest.FieldSettings{{Name: "http.request.*", MaxDepth: 1}},
+			expected: []common.MapStr{{"name": "http", "type": "group", "fields": []common.MapStr{
+				{"name": "request.method", "type": "keyword", "index": false},
+			}}},
+		},
+		{
+			title:    "invalid depth",
+			defs:     []common.MapStr{{"name": "http.*", "external": "test"}},
+			settings: []buildmanifest.FieldSettings{{Name: "http.*", MaxDepth: -1}},
+			fail:     "max_depth must be a positive integer",
+		},
+		{
+			title:    "depth without wildcard",
+			defs:     []common.MapStr{{"name": "http.version", "external": "test"}},
+			settings: []buildmanifest.FieldSettings{{Name: "http.version", MaxDepth: 1}},
+			fail:     "max_depth can only be used in wildcard imports",
+		},
+		{
+			title: "disabled objects",
+			defs:  []common.MapStr{{"name": "process.*", "external": "test"}},
+			expected: []common.MapStr{
+				{"name": "process.args", "type": "keyword"},
+				{"name": "process.env_vars", "type": "object", "enabled": false},
+			},
+		},
+		{
+			title: "under disabled object",
+			defs:  []common.MapStr{{"name": "process.env_vars.*", "external": "test"}},
+			fail:  `no fields found under "process.env_vars"`,
+		},
+		{
+			title: "no fields",
+			defs:  []common.MapStr{{"name": "url.*", "external": "test"}},
+			fail:  `no fields found under "url"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			dm := createDependencyManagerWithFieldSettings(t, c.settings...)
+			dm.schema = map[string][]FieldDefinition{"test": schema}
+			result, changed, err := dm.InjectFields(c.defs)
+			if c.fail != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), c.fail)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, changed)
+			assert.Equal(t, c.expected, result)
+		})
+	}
+}
+
+func TestDependencyManagerLocalOverrides(t *testing.T) {
+	overrides := filepath.Join(t.TempDir(), "ecs_overrides.yml")
+	err := os.WriteFile(overrides, []byte(`
+- name: container
+  type: group
+  fields:
+  - name: id
+    type: wildcard
+    description: Patched container id.
+  - name: runtime.version
+    type: keyword
+    description: Version of the container runtime, not released yet.
+`), 0644)
+	require.NoError(t, err)
+
+	schema, err := parseECSFieldsSchema([]byte(testECSSchema + `
+    container.name:
+      name: name
+      description: Container name.
+      type: keyword
+`))
+	require.NoError(t, err)
+	dm, err := createDependencyManagerWithSchemas(map[string][]FieldDefinition{"ecs": schema}, WithLocalOverrides(overrides))
+	require.NoError(t, err)
+
+	result, _, err := dm.InjectFields([]common.MapStr{
+		{"name": "container.id", "external": "ecs"},
+		{"name": "container.name", "external": "ecs"},
+		{"name": "container.runtime.version", "external": "ecs"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []common.MapStr{
+		{"name": "container.id", "type": "wildcard", "description": "Patched container id."},
+		{"name": "container.name", "type": "keyword", "description": "Container name."},
+		{"name": "container.runtime.version", "type": "keyword", "description": "Version of the container runtime, not released yet."},
+	}, result)
+
+	_, err = createDependencyManagerWithSchemas(nil, WithLocalOverrides(filepath.Join(t.TempDir(), "missing.yml")))
+	assert.Error(t, err)
+}
+
+func TestDependencyManagerImportDateFormats(t *testing.T) {
+	var schema []FieldDefinition
+	err := yaml.Unmarshal([]byte(`
+- name: event.ingested
+  type: date_nanos
+  description: Timestamp when the event arrived, in nanoseconds.
+  date_format: strict_date_optional_time_nanos
+- name: event.created
+  type: date
+  date_format: epoch_millis
+- name: event.code
+  type: keyword
+  date_format: epoch_millis
+`), &schema)
+	require.NoError(t, err)
+	dm, err := createDependencyManagerWithSchemas(map[string][]FieldDefinition{"custom": schema})
+	require.NoError(t, err)
+
+	result, _, err := dm.InjectFields([]common.MapStr{
+		{"name": "event.ingested", "external": "custom"},
+		{"name": "event.created", "external": "custom"},
+		{"name": "event.code", "external": "custom"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []common.MapStr{
+		{"name": "event.ingested", "type": "date_nanos", "description": "Timestamp when the event arrived, in nanoseconds.", "date_format": "strict_date_optional_time_nanos"},
+		{"name": "event.created", "type": "date", "date_format": "epoch_millis"},
+		{"name": "event.code", "type": "keyword"},
+	}, result)
+}
+
+func TestDependencyManagerImportPatterns(t *testing.T) {
+	var schema []FieldDefinition
+	err := yaml.Unmarshal([]byte(`
+- name: log.level
+  type: keyword
+  pattern:
+    - ^[a-z]+$
+    - ^[0-9]+$
+- name: log.origin
+  type: keyword
+  pattern:
+    - ^[a-z]+$
+    - ^[a-z
+`), &schema)
+	require.NoError(t, err)
+	dm, err := createDependencyManagerWithSchemas(map[string][]FieldDefinition{"custom": schema})
+	require.NoError(t, err)
+
+	result, _, err := dm.InjectFields([]common.MapStr{
+		{"name": "log.level", "external": "custom"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []common.MapStr{
+		{"name": "log.level", "type": "keyword", "pattern": "(?:^[a-z]+$)|(?:^[0-9]+$)"},
+	}, result)
+
+	_, err = dm.ImportField("custom", "log.origin")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `invalid pattern in imported field "log.origin"`)
+	}
+}
+
+func TestDependencyManagerDescriptionOverlay(t *testing.T) {
+	overlay := filepath.Join(t.TempDir(), "descriptions.yml")
+	err := os.WriteFile(overlay, []byte(`
+container.id: Identificador único del contenedor.
+`), 0644)
+	require.NoError(t, err)
+	descriptions, err := ReadDescriptionOverlay(overlay)
+	require.NoError(t, err)
+
+	schema, err := parseECSFieldsSchema([]byte(testECSSchema + `
+    container.name:
+      name: name
+      description: Container name.
+      type: keyword
+`))
+	require.NoError(t, err)
+	dm, err := createDependencyManagerWithSchemas(map[string][]FieldDefinition{"ecs": schema}, WithDescriptionOverlay(descriptions))
+	require.NoError(t, err)
+
+	result, _, err := dm.InjectFields([]common.MapStr{
+		{"name": "container.id", "external": "ecs"},
+		{"name": "container.name", "external": "ecs"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []common.MapStr{
+		{"name": "container.id", "type": "keyword", "description": "Identificador único del contenedor."},
+		{"name": "container.name", "type": "keyword", "description": "Container name."},
+	}, result)
+
+	_, err = ReadDescriptionOverlay(filepath.Join(t.TempDir(), "missing.yml"))
+	assert.Error(t, err)
+}
+
+func TestDependencyManagerTypeChanges(t *testing.T) {
+	schema, err := parseECSFieldsSchema([]byte(testECSSchema))
+	require.NoError(t, err)
+	previous := []common.MapStr{
+		{"name": "container", "type": "group", "fields": []interface{}{
+			map[string]interface{}{"name": "id", "type": "wildcard"},
+		}},
+	}
+	defs := func() []common.MapStr {
+		return []common.MapStr{
+			{"name": "container", "type": "group", "fields": []interface{}{
+				map[string]interface{}{"name": "id", "external": "ecs"},
+			}},
+		}
+	}
+
+	dm, err := createDependencyManagerWithSchemas(map[string][]FieldDefinition{"ecs": schema})
+	require.NoError(t, err)
+	_, _, err = dm.InjectFields(defs(), WithPreviousFields(previous))
+	assert.NoError(t, err)
+
+	dm, err = createDependencyManagerWithSchemas(map[string][]FieldDefinition{"ecs": schema}, WithStrictTypeChanges())
+	require.NoError(t, err)
+	_, _, err = dm.InjectFields(defs(), WithPreviousFields(previous))
+	if assert.Error(t, err) {
+		assert.Equal(t, `field "container.id" type changed wildcard → keyword since last build`, err.Error())
+	}
+
+	previous[0]["fields"] = []interface{}{map[string]interface{}{"name": "id", "type": "keyword"}}
+	_, _, err = dm.InjectFields(defs(), WithPreviousFields(previous))
+	assert.NoError(t, err)
+	_, _, err = dm.InjectFields(defs())
+	assert.NoError(t, err)
+}
+
+func TestDependencyManagerNarrowedValues(t *testing.T) {
+	var schema []FieldDefinition
+	err := yaml.Unmarshal([]byte(`
+- name: event.kind
+  type: keyword
+  allowed_values:
+  - name: alert
+  - name: event
+  - name: metric
+- name: event.dataset
+  type: keyword
+`), &schema)
+	require.NoError(t, err)
+
+	cases := []struct {
+		title    string
+		name     string
+		values   []string
+		expected string
+	}{
+		{
+			title:  "valid narrowing",
+			name:   "event.kind",
+			values: []string{"event", "metric"},
+		},
+		{
+			title:    "invalid extra value",
+			name:     "event.kind",
+			values:   []string{"event", "metrics"},
+			expected: `invalid definition of field "event.kind": value "metrics" in expected_values is not allowed by the imported definition (allowed values: alert, event, metric)`,
+		},
+		{
+			title:  "imported field without allowed values",
+			name:   "event.dataset",
+			values: []string{"nginx.access"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			dm := createDependencyManagerWithFieldSettings(t, buildmanifest.FieldSettings{Name: c.name, ExpectedValues: c.values})
+			dm.schema = map[string][]FieldDefinition{"test": schema}
+			_, _, err := dm.InjectFields([]common.MapStr{{"name": c.name, "external": "test"}})
+			if c.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Equal(t, c.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestDependencyManagerImportShortDescriptions(t *testing.T) {
+	var schema FieldDefinitions
+	err := yaml.Unmarshal([]byte(`
+host:
+  name: host
+  type: group
+  fields:
+    hostname:
+      name: hostname
+      type: keyword
+      short: Hostname of the host.
+      description: Hostname of the host. It normally contains what the hostname command returns on the host machine.
+    id:
+      name: id
+      type: keyword
+      short: Unique host id.
+`), &schema)
+	require.NoError(t, err)
+
+	dm := &DependencyManager{schema: map[string][]FieldDefinition{"ecs": schema}}
+	imported, err := dm.ImportField("ecs", "host.hostname")
+	require.NoError(t, err)
+	assert.Equal(t, "Hostname of the host.", imported.Short)
+	assert.Equal(t, "Hostname of the host. It normally contains what the hostname command returns on the host machine.", imported.Description)
+
+	result, _, err := dm.InjectFields([]common.MapStr{
+		{"name": "host.hostname", "external": "ecs"},
+		{"name": "host.id", "external": "ecs"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, imported.Description, result[0]["description"])
+	assert.Equal(t, "Unique host id.", result[1]["description"])
+	assert.NotContains(t, result[0], "short")
+}
+
+// createDependencyManagerWithSchemas creates a dependency manager using the given schemas, by name, instead of
+// loading them from the dependencies defined in a build manifest.
+func createDependencyManagerWithSchemas(schemas map[string][]FieldDefinition, opts ...DependencyManagerOption) (*DependencyManager, error) {
+	dm := &DependencyManager{ctx: context.Background()}
+	for _, opt := range opts {
+		if err := opt(dm); err != nil {
+			return nil, err
+		}
+	}
+	// Schemas are already loaded.
+	dm.lazy = false
+	for name, defs := range schemas {
+		dm.addSchema(name, defs)
+	}
+	return dm, nil
+}
+
+func TestParseSchemaReader(t *testing.T) {
+	ecsSchema, err := parseSchemaReader("ecs", strings.NewReader(testECSSchema))
+	require.NoError(t, err)
+	customSchema, err := parseSchemaReader("custom", strings.NewReader(`
+- name: service
+  type: group
+  fields:
+    - name: tier
+      type: keyword
+      description: Tier of the service.
+`))
+	require.NoError(t, err)
+	_, err = parseSchemaReader("invalid", strings.NewReader("name: [service"))
+	assert.Error(t, err)
+
+	dm, err := createDependencyManagerWithSchemas(map[string][]FieldDefinition{
+		"ecs":    ecsSchema,
+		"custom": customSchema,
+	})
+	require.NoError(t, err)
+
+	result, _, err := dm.InjectFields([]common.MapStr{
+		{"name": "container.id", "external": "ecs"},
+		{"name": "service.tier", "external": "custom"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []common.MapStr{
+		{"name": "container.id", "type": "keyword", "description": "Unique container id."},
+		{"name": "service.tier", "type": "keyword", "description": "Tier of the service."},
+	}, result)
+}
+
+func TestDependencyManagerDuplicatedExternalFields(t *testing.T) {
+	schema := map[string][]FieldDefinition{"test": []FieldDefinition{
+		{
+			Name:        "source.ip",
+			Type:        "ip",
+			Description: "IP address of the source.",
+		},
+	}}
+	defs := func() []common.MapStr {
+		return []common.MapStr{
+			{"name": "source.ip", "external": "test"},
+			{
+				"name": "source",
+				"type": "group",
+				"fields": []interface{}{
+					map[string]interface{}{"name": "ip", "external": "test", "description": "Overridden description."},
+				},
+			},
+		}
+	}
+
+	dm := &DependencyManager{schema: schema}
+	provenance := make(map[string]string)
+	result, changed, err := dm.InjectFields(defs(), WithProvenance(provenance))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []common.MapStr{
+		{"name": "source.ip", "type": "ip", "description": "Overridden description."},
+	}, result)
+	assert.Equal(t, map[string]string{"source.ip": "test"}, provenance)
+
+	dm = &DependencyManager{schema: schema}
+	err = WithStrictDuplicates()(dm)
+	require.NoError(t, err)
+	_, _, err = dm.InjectFields(defs())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `field "source.ip" is imported more than once, as "source.ip" (entry 1) and as "ip" (entry 1 under source group)`)
+	}
+}
+
+func TestDependencyManagerInjectionProvenance(t *testing.T) {
+	schema := map[string][]FieldDefinition{
+		"ecs": []FieldDefinition{
+			{
+				Name: "host.name",
+				Type: "keyword",
+			},
+		},
+		"custom": []FieldDefinition{
+			{
+				Name: "service.tier",
+				Type: "keyword",
+			},
+		},
+	}
+	defs := func() []common.MapStr {
+		return []common.MapStr{
+			{"name": "host.name", "external": "ecs"},
+			{"name": "service.tier", "external": "custom"},
+			{"name": "service.owner", "type": "keyword"},
+		}
+	}
+
+	dm := &DependencyManager{schema: schema}
+	expected, _, err := dm.InjectFields(defs())
+	require.NoError(t, err)
+
+	provenance := make(map[string]string)
+	result, _, err := dm.InjectFields(defs(), WithProvenance(provenance))
+	require.NoError(t, err)
+	assert.Equal(t, expected, result)
+	assert.Equal(t, map[string]string{"host.name": "ecs", "service.tier": "custom"}, provenance)
+
+	_, _, err = dm.InjectFields(defs(), WithProvenance(nil))
+	assert.Error(t, err)
+}
+
+func TestParseImportMode(t *testing.T) {
+	for name, expected := range map[string]ImportMode{
+		"":        ImportModeDefault,
+		"default": ImportModeDefault,
+		"build":   ImportModeBuild,
+		"docs":    ImportModeDocs,
+	} {
+		mode, err := ParseImportMode(name)
+		require.NoError(t, err)
+		assert.Equal(t, expected, mode, name)
+	}
+
+	_, err := ParseImportMode("mappings")
+	assert.Error(t, err)
+}
+
+func TestDependencyManagerImportModes(t *testing.T) {
+	schema := map[string][]FieldDefinition{"test": []FieldDefinition{
+		{
+			Name:        "host.name",
+			Type:        "keyword",
+			Description: "Name of the host.",
+			Example:     "localhost",
+			MultiFields: []FieldDefinition{
+				{
+					Name:        "text",
+					Type:        "match_only_text",
+					Description: "Name of the host, as text.",
+				},
+			},
+		},
+	}}
+	defs := []common.MapStr{{"name": "host.name", "external": "test"}}
+
+	cases := []struct {
+		title      string
+		mode       ImportMode
+		attributes []string
+	}{
+		{"default", ImportModeDefault, []string{"name", "type", "description", "multi_fields"}},
+		{"build", ImportModeBuild, []string{"name", "type", "multi_fields"}},
+		{"docs", ImportModeDocs, []string{"name", "type", "description", "example", "multi_fields"}},
+	}
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			dm := &DependencyManager{schema: schema}
+			err := WithImportMode(c.mode)(dm)
+			require.NoError(t, err)
+
+			result, _, err := dm.InjectFields(defs)
+			require.NoError(t, err)
+			require.Len(t, result, 1)
+
+			var attributes []string
+			for k := range result[0] {
+				attributes = append(attributes, k)
+			}
+			assert.ElementsMatch(t, c.attributes, attributes)
+
+			multiFields, err := toMapStrSlice(result[0]["multi_fields"])
+			require.NoError(t, err)
+			require.Len(t, multiFields, 1)
+			_, found := multiFields[0]["description"]
+			assert.Equal(t, c.mode != ImportModeBuild, found)
+		})
+	}
+}
+
+func TestDependencyManagerTargetSpecVersion(t *testing.T) {
+	var schema []FieldDefinition
+	err := yaml.Unmarshal([]byte(`
+- name: event.category
+  type: keyword
+  normalize:
+    - array
+- name: event.ingested
+  type: date_nanos
+  date_format: strict_date_optional_time_nanos
+- name: event.duration_ms
+  type: long
+  runtime: true
+- name: host.os.full
+  type: keyword
+  flat_name: host.os.full
+  dashed_name: host-os-full
+  multi_fields:
+    - name: text
+      type: match_only_text
+      flat_name: host.os.full.text
+`), &schema)
+	require.NoError(t, err)
+
+	cases := []struct {
+		field       string
+		specVersion string
+		expected    common.MapStr
+	}{
+		{
+			field:       "event.category",
+			specVersion: "1.9.0",
+			expected:    common.MapStr{"name": "event.category", "type": "keyword", "normalize": []string{"array"}},
+		},
+		{
+			field:       "event.category",
+			specVersion: "2.0.0",
+			expected:    common.MapStr{"name": "event.category", "type": "keyword", "normalize": []string{"array"}},
+		},
+		{
+			field:       "event.ingested",
+			specVersion: "1.9.0",
+			expected:    common.MapStr{"name": "event.ingested", "type": "date_nanos", "date_format": "strict_date_optional_time_nanos"},
+		},
+		{
+			field:       "event.ingested",
+			specVersion: "2.0.0",
+			expected:    common.MapStr{"name": "event.ingested", "type": "date_nanos"},
+		},
+		{
+			field:       "event.duration_ms",
+			specVersion: "1.9.0",
+			expected:    common.MapStr{"name": "event.duration_ms", "type": "long", "runtime": true},
+		},
+		{
+			field:       "event.duration_ms",
+			specVersion: "2.0.0",
+			expected:    common.MapStr{"name": "event.duration_ms", "type": "long"},
+		},
+		{
+			field:       "host.os.full",
+			specVersion: "1.9.0",
+			expected: common.MapStr{
+				"name":        "host.os.full",
+				"type":        "keyword",
+				"flat_name":   "host.os.full",
+				"dashed_name": "host-os-full",
+				"multi_fields": []common.MapStr{
+					{"name": "text", "type": "match_only_text", "flat_name": "host.os.full.text"},
+				},
+			},
+		},
+		{
+			field:       "host.os.full",
+			specVersion: "2.0.0",
+			expected: common.MapStr{
+				"name": "host.os.full",
+				"type": "keyword",
+				"multi_fields": []common.MapStr{
+					{"name": "text", "type": "match_only_text"},
+				},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.field+"/"+c.specVersion, func(t *testing.T) {
+			dm := &DependencyManager{schema: map[string][]FieldDefinition{"test": schema}}
+			require.NoError(t, WithTargetSpecVersion(c.specVersion)(dm))
+			require.NoError(t, WithECSNameMetadata()(dm))
+
+			result, _, err := dm.InjectFields([]common.MapStr{{"name": c.field, "external": "test"}})
+			require.NoError(t, err)
+			assert.Equal(t, []common.MapStr{c.expected}, result)
+		})
+	}
+
+	dm := &DependencyManager{}
+	assert.Error(t, WithTargetSpecVersion("not-a-version")(dm))
+}
+
+func TestDependencyManagerInjectFieldsErrorLocation(t *testing.T) {
+	dm := &DependencyManager{schema: map[string][]FieldDefinition{"test": []FieldDefinition{
+		{Name: "source.ip", Type: "ip"},
+	}}}
+	defs := []common.MapStr{
+		{
+			"name": "source",
+			"type": "group",
+			"fields": []interface{}{
+				map[string]interface{}{
+					"name": "network",
+					"type": "group",
+					"fields": []interface{}{
+						map[string]interface{}{"name": "forwarded_ip", "external": "test"},
+					},
+				},
+			},
+		},
+	}
+
+	_, _, err := dm.InjectFields(defs)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "can't import field at source.network.forwarded_ip under source.network group")
+	}
+
+	_, _, err = dm.InjectFields([]common.MapStr{{"name": "source.ip", "external": "unknown"}})
+	if assert.Error(t, err) {
+		assert.Equal(t, `external fields reference schemas not defined as package dependencies in build manifest "_dev/build/build.yml": unknown`, err.Error())
+	}
+}
+
+func TestDependencyManagerObjectCollisions(t *testing.T) {
+	dm := &DependencyManager{schema: map[string][]FieldDefinition{"test": []FieldDefinition{
+		{Name: "source.ip", Type: "ip"},
+		{Name: "labels", Type: "object", ObjectType: "keyword"},
+	}}}
+
+	defs := []common.MapStr{
+		{"name": "source.ip", "external": "test"},
+		{
+			"name": "source",
+			"type": "group",
+			"fields": []interface{}{
+				map[string]interface{}{"name": "ip.v4", "type": "ip"},
+			},
+		},
+		{"name": "labels", "external": "test"},
+		{"name": "labels.env", "type": "keyword"},
+	}
+	_, _, err := dm.InjectFields(defs)
+	if assert.Error(t, err) {
+		assert.Equal(t, `fields defined both as objects and as leaf fields: "source.ip" is a leaf field in "source.ip" (entry 1) and an object in "ip.v4" (entry 1 under source group)`, err.Error())
+	}
+
+	defs = []common.MapStr{
+		{"name": "source.ip", "external": "test"},
+		{
+			"name": "source",
+			"type": "group",
+			"fields": []interface{}{
+				map[string]interface{}{"name": "port", "type": "long"},
+			},
+		},
+		{"name": "labels", "external": "test"},
+		{"name": "labels.env", "type": "keyword"},
+	}
+	_, _, err = dm.InjectFields(defs)
+	assert.NoError(t, err)
+}
+
+func TestDependencyManagerUndeclaredSchemas(t *testing.T) {
+	dm := &DependencyManager{schema: map[string][]FieldDefinition{
+		"ecs": nil,
+		"test": []FieldDefinition{
+			{Name: "source.ip", Type: "ip"},
+		},
+	}}
+	defs := []common.MapStr{
+		{"name": "source.ip", "external": "test"},
+		{"name": "host.name", "external": "ecs"},
+		{
+			"name": "destination",
+			"type": "group",
+			"fields": []interface{}{
+				map[string]interface{}{"name": "ip", "external": "other"},
+				map[string]interface{}{"name": "port", "external": "other"},
+			},
+		},
+	}
+
+	_, _, err := dm.InjectFields(defs)
+	if assert.Error(t, err) {
+		assert.Equal(t, `external fields reference the "ecs" schema, but the ECS reference is not configured, set "dependencies.ecs.reference" in build manifest "_dev/build/build.yml"`, err.Error())
+	}
+
+	dm.deps.ECS.Reference = "git@v8.0.0"
+	_, _, err = dm.InjectFields(defs)
+	if assert.Error(t, err) {
+		assert.Equal(t, `external fields reference schemas not defined as package dependencies in build manifest "_dev/build/build.yml": other`, err.Error())
+	}
+}
+
+func TestDependencyManagerInjectFieldsInvalidGroupFields(t *testing.T) {
+	dm := &DependencyManager{schema: map[string][]FieldDefinition{"test": []FieldDefinition{
+		{Name: "source.ip", Type: "ip"},
+	}}}
+
+	cases := []struct {
+		title    string
+		content  string
+		expected string
+	}{
+		{
+			title: "scalar",
+			content: `
+- name: source
+  type: group
+  fields:
+    - name: network
+      type: group
+      fields: forwarded_ip
+`,
+			expected: `invalid fields of group "source.network", list of field definitions expected, found string "forwarded_ip"`,
+		},
+		{
+			title: "scalar element",
+			content: `
+- name: source
+  type: group
+  fields:
+    - name: ip
+      external: test
+    - port
+`,
+			expected: `invalid field definition #2 in fields of group "source", mapping expected, found string "port"`,
+		},
+		{
+			title: "mapping",
+			content: `
+- name: source
+  type: group
+  fields:
+    name: ip
+    external: test
+`,
+			expected: `invalid fields of group "source", list of field definitions expected, found mapping`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			var defs []common.MapStr
+			err := yaml.Unmarshal([]byte(c.content), &defs)
+			require.NoError(t, err)
+
+			_, _, err = dm.InjectFields(defs)
+			if assert.Error(t, err) {
+				assert.Equal(t, c.expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestDependencyManagerStrictTypes(t *testing.T) {
+	dm := &DependencyManager{schema: map[string][]FieldDefinition{"test": []FieldDefinition{
+		{Name: "event.duration", Type: "long"},
+		{Name: "container.id", Type: "keyword"},
+	}}}
+	require.NoError(t, WithStrictTypes()(dm))
+
+	result, _, err := dm.InjectFields([]common.MapStr{{"name": "event.duration", "external": "test", "type": "integer"}})
+	require.NoError(t, err)
+	assert.Equal(t, "integer", result[0]["type"])
+
+	for _, metricType := range []string{"histogram", "aggregate_metric_double", "scaled_float"} {
+		result, _, err = dm.InjectFields([]common.MapStr{{"name": "event.duration", "external": "test", "type": metricType}})
+		require.NoError(t, err)
+		assert.Equal(t, metricType, result[0]["type"])
+	}
+
+	_, _, err = dm.InjectFields([]common.MapStr{{"name": "container.id", "external": "test", "type": "histogram"}})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `field "container.id" declares type "histogram", incompatible with the imported type "keyword"`)
+	}
+
+	_, _, err = dm.InjectFields([]common.MapStr{{"name": "event.duration", "external": "test", "type": "keyword"}})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `field "event.duration" declares type "keyword", incompatible with the imported type "long"`)
+	}
+}
+
+func TestDependencyManagerIncludeFields(t *testing.T) {
+	schema := map[string][]FieldDefinition{"test": []FieldDefinition{
+		{
+			Name:        "host",
+			Type:        "group",
+			Description: "Host fields.",
+			Fields: []FieldDefinition{
+				{Name: "hostname", Type: "keyword", Description: "Hostname of the host."},
+				{Name: "id", Type: "keyword", Description: "Unique host id."},
+				{Name: "uptime", Type: "long", Description: "Seconds the host has been up."},
+			},
+		},
+	}}
+	include := func(name string, names ...string) *DependencyManager {
+		return &DependencyManager{
+			schema:        schema,
+			fieldSettings: map[string]buildmanifest.FieldSettings{name: {Name: name, IncludeFields: append([]string{}, names...)}},
+		}
+	}
+
+	result, changed, err := include("host", "hostname", "uptime").InjectFields([]common.MapStr{
+		{"name": "host", "external": "test", "doc_values": false},
+	})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []common.MapStr{
+		{
+			"name":        "host",
+			"type":        "group",
+			"description": "Host fields.",
+			"doc_values":  false,
+			"fields": []common.MapStr{
+				{"name": "hostname", "type": "keyword", "description": "Hostname of the host.", "doc_values": false},
+				{"name": "uptime", "type": "long", "description": "Seconds the host has been up.", "doc_values": false},
+			},
+		},
+	}, result)
+
+	_, _, err = include("host", "name").InjectFields([]common.MapStr{
+		{"name": "host", "external": "test"},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "can't include fields of host")
+		assert.Contains(t, err.Error(), "field definition not found in schema (name: host.name)")
+	}
+
+	_, _, err = include("host").InjectFields([]common.MapStr{
+		{"name": "host", "external": "test"},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "include_fields doesn't include any field")
+	}
+
+	_, _, err = include("host.id", "id").InjectFields([]common.MapStr{
+		{"name": "host.id", "external": "test"},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `include_fields can only be used with groups, imported field is of type "keyword"`)
+	}
+}
+
+func TestDependencyManagerImportBetaFields(t *testing.T) {
+	schema := map[string][]FieldDefinition{"test": []FieldDefinition{
+		{
+			Name: "threat.enrichments",
+			Type: "nested",
+			Beta: "This field is beta and subject to change.",
+		},
+	}}
+
+	dm := &DependencyManager{schema: schema}
+	_, err := dm.ImportField("test", "threat.enrichments")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `field "threat.enrichments" is in beta`)
+	}
+
+	dm = &DependencyManager{schema: schema, allowBeta: true}
+	imported, err := dm.ImportField("test", "threat.enrichments")
+	require.NoError(t, err)
+	assert.Equal(t, "nested", imported.Type)
+}
+
+func TestCreateFieldDependencyManagerInvalidReference(t *testing.T) {
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "v8.0.0"}}
+	_, err := CreateFieldDependencyManager(context.Background(), deps)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `invalid ECS reference "v8.0.0" defined in build manifest "_dev/build/build.yml"`)
+		assert.Contains(t, err.Error(), `"git@" prefix expected`)
+	}
+}
+
+func TestDependencyManagerMissingECSReference(t *testing.T) {
+	dm, err := CreateFieldDependencyManager(context.Background(), buildmanifest.Dependencies{})
+	require.NoError(t, err)
+
+	_, err = dm.ImportField("ecs", "container.id")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `ECS reference is not configured, set "dependencies.ecs.reference"`)
+	}
+
+	dm = &DependencyManager{schema: map[string][]FieldDefinition{"ecs": {{Name: "container.id", Type: "keyword"}}}}
+	_, err = dm.ImportField("ecs", "host.id")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "field definition not found in schema")
+	}
+}
+
+func TestCreateFieldDependencyManagerWithSchemaFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ecs/v8.0.0/container.yml":
+			fmt.Fprint(w, testECSSchema)
+		case "/ecs/v8.0.0/empty.yml":
+			fmt.Fprint(w, "")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0", SchemaFile: "container.yml"}}
+	dm, err := CreateFieldDependencyManager(context.Background(), deps)
+	require.NoError(t, err)
+	imported, err := dm.ImportField("ecs", "container.id")
+	require.NoError(t, err)
+	assert.Equal(t, "keyword", imported.Type)
+
+	for _, schemaFile := range []string{"empty.yml", "../container.yml", "container.json"} {
+		deps.ECS.SchemaFile = schemaFile
+		_, err = CreateFieldDependencyManager(context.Background(), deps)
+		assert.Error(t, err, schemaFile)
+	}
+}
+
+func TestCreateFieldDependencyManagerLazySchemaLoading(t *testing.T) {
+	var mutex sync.Mutex
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requested = append(requested, r.URL.Path)
+		mutex.Unlock()
+		if r.URL.Path != "/ecs/v8.0.0/ecs_nested.yml" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, testECSSchema)
+	}))
+	defer server.Close()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+	dm, err := CreateFieldDependencyManager(context.Background(), deps, WithLazySchemaLoading())
+	require.NoError(t, err)
+	_, _, err = dm.InjectFields([]common.MapStr{{"name": "message", "type": "text"}})
+	require.NoError(t, err)
+	assert.Empty(t, requested)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			imported, err := dm.ImportField("ecs", "container.id")
+			assert.NoError(t, err)
+			assert.Equal(t, "keyword", imported.Type)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, []string{"/ecs/v8.0.0/ecs_nested.yml"}, requested)
+
+	deps = buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v9.9.9"}}
+	dm, err = CreateFieldDependencyManager(context.Background(), deps, WithLazySchemaLoading())
+	require.NoError(t, err)
+	_, err = dm.ImportField("ecs", "container.id")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "reference defined in build manifest doesn't exist")
+	}
+	_, err = dm.ListFields("ecs")
+	assert.Error(t, err)
+}
+
+func BenchmarkCreateFieldDependencyManager(b *testing.B) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testECSSchema)
+	}))
+	defer server.Close()
+
+	b.Setenv("ELASTIC_PACKAGE_DATA_HOME", b.TempDir())
+	b.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	defaultParsedSchemas := parsedSchemas
+	defer func() { parsedSchemas = defaultParsedSchemas }()
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+	defs := []common.MapStr{{"name": "message", "type": "text"}}
+	for _, c := range []struct {
+		name string
+		opts []DependencyManagerOption
+	}{
+		{"eager", nil},
+		{"lazy", []DependencyManagerOption{WithLazySchemaLoading()}},
+	} {
+		for _, cached := range []bool{false, true} {
+			name := c.name + "/uncached"
+			if cached {
+				name = c.name + "/cached"
+			}
+			b.Run(name, func(b *testing.B) {
+				parsedSchemas = newParsedSchemaCache(parsedSchemasLimit)
+				for i := 0; i < b.N; i++ {
+					if !cached {
+						// Start every iteration with empty schema caches, so schemas are downloaded and parsed again.
+						b.StopTimer()
+						b.Setenv("ELASTIC_PACKAGE_DATA_HOME", b.TempDir())
+						parsedSchemas = newParsedSchemaCache(parsedSchemasLimit)
+						b.StartTimer()
+					}
+					dm, err := CreateFieldDependencyManager(context.Background(), deps, c.opts...)
+					if err != nil {
+						b.Fatal(err)
+					}
+					_, _, err = dm.InjectFields(defs)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCreateFieldDependencyManagerNotWritableCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testECSSchema)
+	}))
+	defer server.Close()
+
+	defaultWriteCachedSchemaFile := writeCachedSchemaFile
+	writeCachedSchemaFile = func(name string, _ []byte, _ os.FileMode) error {
+		return &os.PathError{Op: "open", Path: name, Err: syscall.EROFS}
+	}
+	defer func() { writeCachedSchemaFile = defaultWriteCachedSchemaFile }()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+	dm, err := CreateFieldDependencyManager(context.Background(), deps)
+	require.NoError(t, err)
+	imported, err := dm.ImportField("ecs", "container.id")
+	require.NoError(t, err)
+	assert.Equal(t, "keyword", imported.Type)
+}
+
+func TestCreateFieldDependencyManagerCanceled(t *testing.T) {
+	requested := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-requested
+		cancel()
+	}()
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+	_, err := CreateFieldDependencyManager(ctx, deps)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCachedSchemaCompression(t *testing.T) {
+	cacheDir := t.TempDir()
+	path := filepath.Join(cacheDir, "ecs", "v8.0.0", "ecs_nested.yml")
+
+	// Plain schemas cached by previous versions are still read.
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte("plain"), 0644))
+	content, err := readCachedSchema(path)
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(content))
+
+	require.NoError(t, writeCachedSchema(path, []byte(testECSSchema)))
+	assert.NoFileExists(t, path)
+	assert.FileExists(t, path+compressedSchemaExt)
+	content, err = readCachedSchema(path)
+	require.NoError(t, err)
+	assert.Equal(t, testECSSchema, string(content))
+
+	_, err = readCachedSchema(filepath.Join(cacheDir, "ecs", "v8.1.0", "ecs_nested.yml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSchemaSourcePinned(t *testing.T) {
+	cases := []struct {
+		reference string
+		pinned    bool
+	}{
+		{"git@v8.11.0", true},
+		{"git@v8.0.0-rc1", true},
+		{"git@0b8b7d6121340e99a1eb463c91fd1bc7c9eb2e41", true},
+		{"git@0b8b7d6", true},
+		{"git@main", false},
+		{"git@8.11", false},
+		{"git@1.9", false},
+	}
+	for _, c := range cases {
+		source, err := newSchemaSource(c.reference)
+		require.NoError(t, err)
+		assert.Equal(t, c.pinned, source.pinned(), c.reference)
+	}
+}
+
+func TestParseECSFieldsSchemaWindowsFormat(t *testing.T) {
+	expected, err := parseECSFieldsSchema([]byte(testECSSchema))
+	require.NoError(t, err)
+
+	content := "\xef\xbb\xbf" + strings.ReplaceAll(testECSSchema, "\n", "\r\n")
+	fields, err := parseECSFieldsSchema([]byte(content))
+	require.NoError(t, err)
+	assert.Equal(t, expected, fields)
+}
+
+func TestCachedSchemaFilePath(t *testing.T) {
+	cacheDir := filepath.Join("tmp", "cache")
+
+	path, err := cachedSchemaFilePath(cacheDir, "ecs", "v8.0.0", "ecs_nested.yml")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(cacheDir, "ecs", "v8.0.0", "ecs_nested.yml"), path)
+
+	_, err = cachedSchemaFilePath(cacheDir, "ecs", "../../..", "ecs_nested.yml")
+	assert.Error(t, err)
+}
+
+func TestAsGitReference(t *testing.T) {
+	cases := []struct {
+		reference string
+		expected  string
+		valid     bool
+	}{
+		{"git@v8.0.0", "v8.0.0", true},
+		{"git@1.10", "1.10", true},
+		{"git@0b8b7d6121340e99a1eb463c91fd1bc7c9eb2e41", "0b8b7d6121340e99a1eb463c91fd1bc7c9eb2e41", true},
+		{"v8.0.0", "", false},
+		{"git@", "", false},
+		{"git@..", "", false},
+		{"git@../../../etc", "", false},
+		{"git@/etc/passwd", "", false},
+		{`git@..\..\windows`, "", false},
+		{"git@C:", "", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.reference, func(t *testing.T) {
+			gitReference, err := asGitReference(c.reference)
+			if !c.valid {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.expected, gitReference)
+		})
+	}
+}
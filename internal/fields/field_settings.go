@@ -0,0 +1,104 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"fmt"
+
+	"github.com/elastic/elastic-package/internal/common"
+	"github.com/elastic/elastic-package/internal/packages/buildmanifest"
+)
+
+// fieldSettingsDirectives are the attributes applied when importing fields that are defined in the settings of the
+// fields in the build manifest, as they are not valid in fields files.
+var fieldSettingsDirectives = []string{
+	removeMultiFieldsDirective,
+	keepMultiFieldsDirective,
+	includeFieldsDirective,
+	maxDepthDirective,
+	keepImportedTypeDirective,
+	addNormalizeDirective,
+}
+
+// indexFieldSettings returns the given settings of imported fields by field path.
+func indexFieldSettings(settings []buildmanifest.FieldSettings) (map[string]buildmanifest.FieldSettings, error) {
+	if len(settings) == 0 {
+		return nil, nil
+	}
+	index := make(map[string]buildmanifest.FieldSettings, len(settings))
+	for _, s := range settings {
+		if s.Name == "" {
+			return nil, fmt.Errorf("missing name in settings of imported fields")
+		}
+		if _, found := index[s.Name]; found {
+			return nil, fmt.Errorf("settings of field %q defined more than once", s.Name)
+		}
+		index[s.Name] = s
+	}
+	return index, nil
+}
+
+// applyFieldSettings returns the local definition of an imported field with the directives defined in its settings,
+// if any. The given definition is not modified.
+func (dm *DependencyManager) applyFieldSettings(fieldPath string, def common.MapStr) common.MapStr {
+	if dm == nil {
+		return def
+	}
+	settings, found := dm.fieldSettings[fieldPath]
+	if !found {
+		return def
+	}
+
+	applied := make(common.MapStr, len(def)+len(fieldSettingsDirectives))
+	for k, v := range def {
+		applied[k] = v
+	}
+	if len(settings.RemoveMultiFields) > 0 {
+		applied[removeMultiFieldsDirective] = settings.RemoveMultiFields
+	}
+	if settings.KeepMultiFields != nil {
+		applied[keepMultiFieldsDirective] = *settings.KeepMultiFields
+	}
+	if settings.IncludeFields != nil {
+		applied[includeFieldsDirective] = settings.IncludeFields
+	}
+	if settings.MaxDepth != 0 {
+		applied[maxDepthDirective] = settings.MaxDepth
+	}
+	if settings.KeepImportedType {
+		applied[keepImportedTypeDirective] = true
+	}
+	if len(settings.NormalizeAdd) > 0 {
+		applied[addNormalizeDirective] = settings.NormalizeAdd
+	}
+	return applied
+}
+
+// checkFieldDirectives checks that the given definitions and their child fields don't define the directives that
+// are defined in the settings of the fields in the build manifest.
+func checkFieldDirectives(root string, defs []common.MapStr) error {
+	for _, def := range defs {
+		fieldPath := buildFieldPath(root, def)
+		for _, directive := range fieldSettingsDirectives {
+			if _, found := def[directive]; found {
+				return fmt.Errorf("field %q defines %s, it must be defined in the settings of the field in the build manifest (dependencies.ecs.fields)", fieldPath, directive)
+			}
+		}
+
+		fields, _ := def.GetValue("fields")
+		if fields == nil {
+			continue
+		}
+		fieldsMs, err := groupFields(fieldPath, fields)
+		if err != nil {
+			return err
+		}
+		err = checkFieldDirectives(fieldPath, fieldsMs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-package/internal/common"
+	"github.com/elastic/elastic-package/internal/packages/buildmanifest"
+)
+
+var fieldSettingsTestSchema = map[string][]FieldDefinition{"test": {
+	{
+		Name:        "user.name",
+		Description: "Short name or login of the user.",
+		Type:        "keyword",
+		MultiFields: []FieldDefinition{
+			{Name: "text", Type: "match_only_text"},
+			{Name: "caseless", Type: "keyword"},
+		},
+	},
+}}
+
+// createDependencyManagerWithFieldSettings returns a dependency manager with the test schema and the given
+// settings of imported fields.
+func createDependencyManagerWithFieldSettings(t *testing.T, settings ...buildmanifest.FieldSettings) *DependencyManager {
+	index, err := indexFieldSettings(settings)
+	require.NoError(t, err)
+	return &DependencyManager{schema: fieldSettingsTestSchema, fieldSettings: index}
+}
+
+func TestIndexFieldSettings(t *testing.T) {
+	index, err := indexFieldSettings([]buildmanifest.FieldSettings{{Name: "user.name"}, {Name: "user.id"}})
+	require.NoError(t, err)
+	assert.Len(t, index, 2)
+
+	_, err = indexFieldSettings([]buildmanifest.FieldSettings{{Name: "user.name"}, {Name: "user.name"}})
+	assert.Error(t, err)
+	_, err = indexFieldSettings([]buildmanifest.FieldSettings{{}})
+	assert.Error(t, err)
+}
+
+func TestDependencyManagerFieldDirectivesInFieldsFiles(t *testing.T) {
+	dm := createDependencyManagerWithFieldSettings(t)
+	_, _, err := dm.InjectFields([]common.MapStr{
+		{
+			"name": "user",
+			"type": "group",
+			"fields": []interface{}{
+				map[string]interface{}{"name": "name", "external": "test", "remove_multi_fields": []interface{}{"text"}},
+			},
+		},
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `field "user.name" defines remove_multi_fields, it must be defined in the settings of the field in the build manifest`)
+	}
+}
+
+func TestDependencyManagerRemoveMultiFields(t *testing.T) {
+	cases := []struct {
+		title    string
+		removed  []string
+		expected []common.MapStr
+	}{
+		{
+			title:    "remove one multi field",
+			removed:  []string{"text"},
+			expected: []common.MapStr{{"name": "caseless", "type": "keyword"}},
+		},
+		{
+			title:   "remove all multi fields",
+			removed: []string{"text", "caseless"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			dm := createDependencyManagerWithFieldSettings(t, buildmanifest.FieldSettings{Name: "user.name", RemoveMultiFields: c.removed})
+			result, _, err := dm.InjectFields([]common.MapStr{{"name": "user.name", "external": "test"}})
+			require.NoError(t, err)
+
+			expected := common.MapStr{
+				"name":        "user.name",
+				"type":        "keyword",
+				"description": "Short name or login of the user.",
+			}
+			if c.expected != nil {
+				expected["multi_fields"] = c.expected
+			}
+			assert.Equal(t, []common.MapStr{expected}, result)
+		})
+	}
+}
+
+func TestDependencyManagerKeepMultiFields(t *testing.T) {
+	kept, notKept := true, false
+	cases := []struct {
+		title    string
+		keep     *bool
+		expected int
+	}{
+		{title: "not set", expected: 2},
+		{title: "kept", keep: &kept, expected: 2},
+		{title: "not kept", keep: &notKept},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			dm := createDependencyManagerWithFieldSettings(t, buildmanifest.FieldSettings{Name: "user.name", KeepMultiFields: c.keep})
+			result, _, err := dm.InjectFields([]common.MapStr{{"name": "user.name", "external": "test"}})
+			require.NoError(t, err)
+			require.Len(t, result, 1)
+			multiFields, _ := result[0]["multi_fields"].([]common.MapStr)
+			assert.Len(t, multiFields, c.expected)
+			assert.NotContains(t, result[0], keepMultiFieldsDirective)
+		})
+	}
+}
+
+func TestDependencyManagerKeepImportedType(t *testing.T) {
+	cases := []struct {
+		title    string
+		ttype    string
+		keep     bool
+		expected string
+	}{
+		{title: "constant_keyword override", ttype: "constant_keyword", expected: "constant_keyword"},
+		{title: "compatible override", ttype: "wildcard", expected: "wildcard"},
+		{title: "constant_keyword with imported type kept", ttype: "constant_keyword", keep: true, expected: "keyword"},
+		{title: "compatible type with imported type kept", ttype: "wildcard", keep: true, expected: "keyword"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			dm := createDependencyManagerWithFieldSettings(t, buildmanifest.FieldSettings{Name: "user.name", KeepImportedType: c.keep})
+			result, _, err := dm.InjectFields([]common.MapStr{{"name": "user.name", "external": "test", "type": c.ttype}})
+			require.NoError(t, err)
+			require.Len(t, result, 1)
+			assert.Equal(t, c.expected, result[0]["type"])
+			assert.NotContains(t, result[0], keepImportedTypeDirective)
+		})
+	}
+}
+
+func TestDependencyManagerNormalizeAdd(t *testing.T) {
+	schema := map[string][]FieldDefinition{"test": {
+		{Name: "container.id", Type: "keyword"},
+		{Name: "host.ip", Type: "ip", Normalize: []string{"array"}},
+	}}
+	cases := []struct {
+		title    string
+		def      common.MapStr
+		expected []string
+	}{
+		{
+			title:    "added",
+			def:      common.MapStr{"name": "container.id", "external": "test"},
+			expected: []string{"array"},
+		},
+		{
+			title:    "added to imported normalizations",
+			def:      common.MapStr{"name": "host.ip", "external": "test"},
+			expected: []string{"array"},
+		},
+		{
+			title:    "added to cleared normalizations",
+			def:      common.MapStr{"name": "host.ip", "external": "test", "normalize": []interface{}{}},
+			expected: []string{"array"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			dm := createDependencyManagerWithFieldSettings(t, buildmanifest.FieldSettings{Name: c.def["name"].(string), NormalizeAdd: []string{"array"}})
+			dm.schema = schema
+			result, _, err := dm.InjectFields([]common.MapStr{c.def})
+			require.NoError(t, err)
+			require.Len(t, result, 1)
+			assert.Equal(t, c.expected, result[0]["normalize"])
+			assert.NotContains(t, result[0], addNormalizeDirective)
+		})
+	}
+}
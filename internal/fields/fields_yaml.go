@@ -0,0 +1,124 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/elastic/elastic-package/internal/common"
+)
+
+// leadingFieldKeys are the keys placed first in marshalled field definitions, in this order.
+var leadingFieldKeys = []string{"name", "type"}
+
+// trailingFieldKeys are the keys placed last in marshalled field definitions, in this order.
+var trailingFieldKeys = []string{"multi_fields", "fields"}
+
+// MarshalFields function marshals field definitions, like the ones returned by InjectFields, to the YAML
+// format of fields files. Keys are ordered deterministically: name and type first, then other attributes
+// in alphabetical order, and finally multi-fields and child fields.
+func MarshalFields(defs []common.MapStr) ([]byte, error) {
+	node, err := fieldsNode(defs)
+	if err != nil {
+		return nil, err
+	}
+	content, err := yaml.Marshal(node)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't marshal fields")
+	}
+	return content, nil
+}
+
+// BuildFields function reads a fields file, injects the external fields it imports with the given dependency
+// manager, and returns the resulting fields file, marshalled with MarshalFields. Files without external fields
+// are returned as they are, and reported as not changed.
+func BuildFields(path string, dm *DependencyManager, opts ...InjectFieldsOption) ([]byte, bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "can't read fields file")
+	}
+
+	var defs []common.MapStr
+	err = yaml.Unmarshal(content, &defs)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "can't unmarshal fields file (path: %s)", path)
+	}
+
+	defs, changed, err := dm.InjectFields(defs, opts...)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "can't resolve fields (path: %s)", path)
+	}
+	if !changed {
+		return content, false, nil
+	}
+	content, err = MarshalFields(defs)
+	if err != nil {
+		return nil, false, err
+	}
+	return content, true, nil
+}
+
+func fieldsNode(defs []common.MapStr) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, def := range defs {
+		defNode, err := fieldNode(def)
+		if err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, defNode)
+	}
+	return node, nil
+}
+
+func fieldNode(def common.MapStr) (*yaml.Node, error) {
+	var keys []string
+	for k := range def {
+		if !common.StringSliceContains(leadingFieldKeys, k) && !common.StringSliceContains(trailingFieldKeys, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	keys = append(append(append([]string{}, leadingFieldKeys...), keys...), trailingFieldKeys...)
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, k := range keys {
+		v, found := def[k]
+		if !found {
+			continue
+		}
+
+		var valueNode *yaml.Node
+		if k == "fields" || k == "multi_fields" {
+			children, err := toMapStrSlice(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "can't convert %s", k)
+			}
+			valueNode, err = fieldsNode(children)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			valueNode = new(yaml.Node)
+			err := valueNode.Encode(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "can't encode attribute %q", k)
+			}
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, valueNode)
+	}
+	return node, nil
+}
+
+// toMapStrSlice converts slices of definitions found in MapStr values.
+func toMapStrSlice(v interface{}) ([]common.MapStr, error) {
+	if ms, ok := v.([]common.MapStr); ok {
+		return ms, nil
+	}
+	return common.ToMapStrSlice(v)
+}
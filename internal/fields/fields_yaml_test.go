@@ -0,0 +1,101 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-package/internal/common"
+)
+
+func TestMarshalFields(t *testing.T) {
+	defs := []common.MapStr{
+		{
+			"type":        "group",
+			"name":        "process",
+			"description": "Process fields.",
+			"fields": []interface{}{
+				map[string]interface{}{
+					"name":        "command_line",
+					"type":        "wildcard",
+					"description": "Full command line that started the process.",
+					"multi_fields": []common.MapStr{
+						{"type": "match_only_text", "name": "text"},
+					},
+					"doc_values": false,
+				},
+			},
+		},
+		{
+			"name":       "labels",
+			"type":       "object",
+			"normalize":  []string{"array"},
+			"example":    "true",
+			"index":      true,
+			"dimension":  true,
+			"other_type": nil,
+		},
+	}
+
+	expected := `- name: process
+  type: group
+  description: Process fields.
+  fields:
+    - name: command_line
+      type: wildcard
+      description: Full command line that started the process.
+      doc_values: false
+      multi_fields:
+        - name: text
+          type: match_only_text
+- name: labels
+  type: object
+  dimension: true
+  example: "true"
+  index: true
+  normalize:
+    - array
+  other_type: null
+`
+	for i := 0; i < 5; i++ {
+		content, err := MarshalFields(defs)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+}
+
+func TestBuildFields(t *testing.T) {
+	schema, err := parseECSFieldsSchema([]byte(testECSSchema))
+	require.NoError(t, err)
+	dm, err := createDependencyManagerWithSchemas(map[string][]FieldDefinition{"ecs": schema})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	external := filepath.Join(dir, "ecs.yml")
+	err = os.WriteFile(external, []byte("- name: container.id\n  external: ecs\n"), 0644)
+	require.NoError(t, err)
+	content, changed, err := BuildFields(external, dm)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "- name: container.id\n  type: keyword\n  description: Unique container id.\n", string(content))
+
+	// Files without external fields are kept as they are.
+	local := filepath.Join(dir, "fields.yml")
+	source := "# Local fields.\n- name: message\n  type: text\n"
+	err = os.WriteFile(local, []byte(source), 0644)
+	require.NoError(t, err)
+	content, changed, err = BuildFields(local, dm)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, source, string(content))
+
+	_, _, err = BuildFields(filepath.Join(dir, "missing.yml"), dm)
+	assert.Error(t, err)
+}
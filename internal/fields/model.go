@@ -15,22 +15,35 @@ import (
 
 // FieldDefinition describes a single field with its properties.
 type FieldDefinition struct {
-	Name           string            `yaml:"name"`
-	Description    string            `yaml:"description"`
-	Type           string            `yaml:"type"`
-	ObjectType     string            `yaml:"object_type"`
-	Value          string            `yaml:"value"` // The value to associate with a constant_keyword field.
-	AllowedValues  AllowedValues     `yaml:"allowed_values"`
-	ExpectedValues []string          `yaml:"expected_values"`
-	Pattern        string            `yaml:"pattern"`
-	Unit           string            `yaml:"unit"`
-	MetricType     string            `yaml:"metric_type"`
-	External       string            `yaml:"external"`
-	Index          *bool             `yaml:"index"`
-	DocValues      *bool             `yaml:"doc_values"`
-	Normalize      []string          `yaml:"normalize,omitempty"`
-	Fields         FieldDefinitions  `yaml:"fields,omitempty"`
-	MultiFields    []FieldDefinition `yaml:"multi_fields,omitempty"`
+	Name                  string            `yaml:"name"`
+	Description           string            `yaml:"description"`
+	Short                 string            `yaml:"short"` // Short form of the description, used by ECS.
+	Example               interface{}       `yaml:"example,omitempty"`
+	Type                  string            `yaml:"type"`
+	ObjectType            string            `yaml:"object_type"`
+	ObjectTypeMappingType string            `yaml:"object_type_mapping_type"`
+	Value                 string            `yaml:"value"` // The value to associate with a constant_keyword field.
+	AllowedValues         AllowedValues     `yaml:"allowed_values"`
+	ExpectedValues        []string          `yaml:"expected_values"`
+	Pattern               string            `yaml:"pattern"`
+	Patterns              []string          `yaml:"-"` // Patterns defined as a list, e.g. in custom schemas, a value must match any of them.
+	Unit                  string            `yaml:"unit"`
+	DateFormat            string            `yaml:"date_format"` // Format of date and date_nanos fields, as in mappings.
+	MetricType            string            `yaml:"metric_type"`
+	External              string            `yaml:"external"`
+	Path                  string            `yaml:"path"` // The target field of an alias field.
+	Beta                  string            `yaml:"beta"` // Description of the beta status of the field, if any.
+	Root                  bool              `yaml:"root"` // Root groups contain fields that appear at the root level of documents.
+	Index                 *bool             `yaml:"index"`
+	DocValues             *bool             `yaml:"doc_values"`
+	Enabled               *bool             `yaml:"enabled"`           // Disabled objects are stored, but their contents are not parsed nor indexed.
+	Runtime               interface{}       `yaml:"runtime,omitempty"` // Runtime fields are defined with true, or with a script.
+	Normalize             []string          `yaml:"normalize,omitempty"`
+	Fields                FieldDefinitions  `yaml:"fields,omitempty"`
+	MultiFields           []FieldDefinition `yaml:"multi_fields,omitempty"`
+
+	// Extra contains the attributes of the definition that are not modeled by other fields.
+	Extra map[string]interface{} `yaml:",inline"`
 }
 
 func (orig *FieldDefinition) Update(fd FieldDefinition) {
@@ -40,12 +53,21 @@ func (orig *FieldDefinition) Update(fd FieldDefinition) {
 	if fd.Description != "" {
 		orig.Description = fd.Description
 	}
+	if fd.Short != "" {
+		orig.Short = fd.Short
+	}
+	if fd.Example != nil {
+		orig.Example = fd.Example
+	}
 	if fd.Type != "" {
 		orig.Type = fd.Type
 	}
 	if fd.ObjectType != "" {
 		orig.ObjectType = fd.ObjectType
 	}
+	if fd.ObjectTypeMappingType != "" {
+		orig.ObjectTypeMappingType = fd.ObjectTypeMappingType
+	}
 	if fd.Value != "" {
 		orig.Value = fd.Value
 	}
@@ -57,22 +79,42 @@ func (orig *FieldDefinition) Update(fd FieldDefinition) {
 	}
 	if fd.Pattern != "" {
 		orig.Pattern = fd.Pattern
+		orig.Patterns = nil
+	}
+	if len(fd.Patterns) > 0 {
+		orig.Pattern = ""
+		orig.Patterns = fd.Patterns
 	}
 	if fd.Unit != "" {
 		orig.Unit = fd.Unit
 	}
+	if fd.DateFormat != "" {
+		orig.DateFormat = fd.DateFormat
+	}
 	if fd.MetricType != "" {
 		orig.MetricType = fd.MetricType
 	}
 	if fd.External != "" {
 		orig.External = fd.External
 	}
+	if fd.Path != "" {
+		orig.Path = fd.Path
+	}
+	if fd.Beta != "" {
+		orig.Beta = fd.Beta
+	}
 	if fd.Index != nil {
 		orig.Index = fd.Index
 	}
 	if fd.DocValues != nil {
 		orig.DocValues = fd.DocValues
 	}
+	if fd.Enabled != nil {
+		orig.Enabled = fd.Enabled
+	}
+	if fd.Runtime != nil {
+		orig.Runtime = fd.Runtime
+	}
 
 	if len(fd.Normalize) > 0 {
 		orig.Normalize = common.StringSlicesUnion(orig.Normalize, fd.Normalize)
@@ -85,6 +127,87 @@ func (orig *FieldDefinition) Update(fd FieldDefinition) {
 	if len(fd.MultiFields) > 0 {
 		orig.MultiFields = updateFields(orig.MultiFields, fd.MultiFields)
 	}
+
+	if len(fd.Extra) > 0 {
+		extra := make(map[string]interface{}, len(orig.Extra)+len(fd.Extra))
+		for k, v := range orig.Extra {
+			extra[k] = v
+		}
+		for k, v := range fd.Extra {
+			extra[k] = v
+		}
+		orig.Extra = extra
+	}
+}
+
+// UnmarshalYAML decodes a field definition, whose pattern can be defined as a single string or as a list.
+func (orig *FieldDefinition) UnmarshalYAML(value *yaml.Node) error {
+	type plainFieldDefinition FieldDefinition
+
+	var patterns []string
+	if value.Kind == yaml.MappingNode {
+		node := *value
+		node.Content = nil
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			key, v := value.Content[i], value.Content[i+1]
+			if key.Value == "pattern" && v.Kind == yaml.SequenceNode {
+				err := v.Decode(&patterns)
+				if err != nil {
+					return fmt.Errorf("invalid list of patterns: %w", err)
+				}
+				continue
+			}
+			node.Content = append(node.Content, key, v)
+		}
+		value = &node
+	}
+
+	err := value.Decode((*plainFieldDefinition)(orig))
+	if err != nil {
+		return err
+	}
+	if len(patterns) > 0 {
+		orig.Patterns = patterns
+	}
+	return nil
+}
+
+// MarshalYAML encodes a field definition, with its patterns as a list if they were defined as a list.
+func (orig FieldDefinition) MarshalYAML() (interface{}, error) {
+	type plainFieldDefinition FieldDefinition
+
+	var node yaml.Node
+	err := node.Encode(plainFieldDefinition(orig))
+	if err != nil {
+		return nil, err
+	}
+	if len(orig.Patterns) == 0 {
+		return &node, nil
+	}
+
+	var patterns yaml.Node
+	err = patterns.Encode(orig.Patterns)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == "pattern" {
+			node.Content[i+1] = &patterns
+			return &node, nil
+		}
+	}
+	return &node, nil
+}
+
+// AllPatterns returns the patterns of the definition, defined as a single pattern or as a list.
+func (orig FieldDefinition) AllPatterns() []string {
+	if len(orig.Patterns) > 0 {
+		return orig.Patterns
+	}
+	if orig.Pattern != "" {
+		return []string{orig.Pattern}
+	}
+	return nil
 }
 
 func updateFields(origFields, fields []FieldDefinition) []FieldDefinition {
@@ -178,19 +301,24 @@ func (fds *FieldDefinitions) UnmarshalYAML(value *yaml.Node) error {
 // defined base fields.
 // If a field name is prefixed by the parent field, this part is removed,
 // so the full path, taking into account the parent name, matches.
-// If a field name is not prefixed by the parent field, this is considered
-// a base field, that should appear at the top-level. It is removed from
-// the list of nested fields and returned as base field.
+// If a field name is not prefixed by the parent field and the parent is a
+// root group, this is considered a base field, that should appear at the
+// top-level. It is removed from the list of nested fields and returned as
+// base field. Other fields are kept as nested fields with relative names,
+// so custom schemas can define multiple levels of nested groups.
 func cleanNested(parent *FieldDefinition) (base []FieldDefinition) {
 	var nested []FieldDefinition
 	for _, field := range parent.Fields {
 		// If the field name is prefixed by the name of its parent,
-		// this is a normal nested field. If not, it is a base field.
+		// this is a normal nested field. If not, it is a base field
+		// when defined in a root group.
 		if strings.HasPrefix(field.Name, parent.Name+".") {
 			field.Name = field.Name[len(parent.Name)+1:]
 			nested = append(nested, field)
-		} else {
+		} else if parent.Root {
 			base = append(base, field)
+		} else {
+			nested = append(nested, field)
 		}
 	}
 
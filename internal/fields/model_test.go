@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
 )
 
 func TestFieldDefinitionUpdate(t *testing.T) {
@@ -172,3 +173,78 @@ func TestFieldDefinitionUpdate(t *testing.T) {
 		})
 	}
 }
+
+func TestFieldDefinitionsUnmarshalNestedMaps(t *testing.T) {
+	content := []byte(`
+base:
+  root: true
+  fields:
+    "@timestamp":
+      type: date
+custom:
+  type: group
+  fields:
+    custom.id:
+      type: keyword
+    network:
+      type: group
+      fields:
+        bytes:
+          type: long
+`)
+
+	var fields FieldDefinitions
+	err := yaml.Unmarshal(content, &fields)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for key, expectedType := range map[string]string{
+		"@timestamp":           "date",
+		"custom.id":            "keyword",
+		"custom.network":       "group",
+		"custom.network.bytes": "long",
+	} {
+		def := FindElementDefinition(key, fields)
+		if assert.NotNil(t, def, key) {
+			assert.Equal(t, expectedType, def.Type, key)
+		}
+	}
+	assert.Nil(t, FindElementDefinition("base.@timestamp", fields))
+	assert.Nil(t, FindElementDefinition("bytes", fields))
+}
+
+func TestFieldDefinitionsUnmarshalPatterns(t *testing.T) {
+	content := []byte(`
+- name: source.mac
+  type: keyword
+  pattern: ^[A-F0-9]{2}(-[A-F0-9]{2}){5,}$
+- name: log.level
+  type: keyword
+  pattern:
+    - ^[a-z]+$
+    - ^[0-9]+$
+  custom: value
+`)
+
+	var fields FieldDefinitions
+	err := yaml.Unmarshal(content, &fields)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, fields, 2) {
+		return
+	}
+
+	assert.Equal(t, "^[A-F0-9]{2}(-[A-F0-9]{2}){5,}$", fields[0].Pattern)
+	assert.Empty(t, fields[0].Patterns)
+	assert.Equal(t, []string{"^[A-F0-9]{2}(-[A-F0-9]{2}){5,}$"}, fields[0].AllPatterns())
+
+	assert.Empty(t, fields[1].Pattern)
+	assert.Equal(t, []string{"^[a-z]+$", "^[0-9]+$"}, fields[1].Patterns)
+	assert.Equal(t, "keyword", fields[1].Type)
+	assert.Equal(t, map[string]interface{}{"custom": "value"}, fields[1].Extra)
+
+	err = yaml.Unmarshal([]byte("- name: log.level\n  pattern: [[a]]\n"), &fields)
+	assert.Error(t, err)
+}
@@ -0,0 +1,119 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/elastic/elastic-package/internal/logger"
+)
+
+const parsedSchemasLimit = 8
+
+// parsedSchemas keeps the schemas parsed in this process, so they are not parsed again
+// when multiple dependency managers are created for the same schema files.
+var parsedSchemas = newParsedSchemaCache(parsedSchemasLimit)
+
+// schemaLRU is a bounded map of schemas, safe for concurrent use. When the limit is reached, the least
+// recently used entry is evicted. Cached schemas are shared, so they must not be modified.
+type schemaLRU struct {
+	mutex   sync.Mutex
+	limit   int
+	entries map[string]interface{}
+	// recent contains the keys of entries, from least to most recently used.
+	recent []string
+}
+
+func newSchemaLRU(limit int) *schemaLRU {
+	return &schemaLRU{
+		limit:   limit,
+		entries: make(map[string]interface{}),
+	}
+}
+
+// get returns the entry stored with the given key, if any, and marks it as the most recently used one.
+func (c *schemaLRU) get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	value, found := c.entries[key]
+	if found {
+		c.touch(key)
+	}
+	return value, found
+}
+
+// put stores an entry with the given key, replacing the one stored before, if any.
+func (c *schemaLRU) put(key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, found := c.entries[key]; !found && len(c.entries) >= c.limit {
+		oldest := c.recent[0]
+		c.recent = c.recent[1:]
+		delete(c.entries, oldest)
+	}
+	c.entries[key] = value
+	c.touch(key)
+}
+
+// len returns the number of stored entries.
+func (c *schemaLRU) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.entries)
+}
+
+// touch marks the key as the most recently used one. It must be called with the mutex locked.
+func (c *schemaLRU) touch(key string) {
+	for i, k := range c.recent {
+		if k == key {
+			c.recent = append(c.recent[:i], c.recent[i+1:]...)
+			break
+		}
+	}
+	c.recent = append(c.recent, key)
+}
+
+// parsedSchemaCache is a bounded cache of parsed schemas, keyed by schema identifiers and
+// validated with the checksum of the schema content.
+type parsedSchemaCache struct {
+	entries *schemaLRU
+}
+
+type parsedSchemaEntry struct {
+	checksum uint64
+	fields   []FieldDefinition
+}
+
+func newParsedSchemaCache(limit int) *parsedSchemaCache {
+	return &parsedSchemaCache{
+		entries: newSchemaLRU(limit),
+	}
+}
+
+// parse returns the parsed schema for the given content, parsing it only if it isn't
+// already cached for the key with the same content.
+func (c *parsedSchemaCache) parse(key string, content []byte, parse func([]byte) ([]FieldDefinition, error)) ([]FieldDefinition, error) {
+	checksum := xxhash.Sum64(content)
+
+	value, found := c.entries.get(key)
+	switch {
+	case found && value.(parsedSchemaEntry).checksum == checksum:
+		logger.Debugf("Parsed schema cache hit: %s", key)
+		return value.(parsedSchemaEntry).fields, nil
+	case found:
+		logger.Debugf("Parsed schema cache invalidated (checksum mismatch): %s", key)
+	default:
+		logger.Debugf("Parsed schema cache miss (not present): %s", key)
+	}
+
+	fields, err := parse(content)
+	if err != nil {
+		return nil, err
+	}
+	c.entries.put(key, parsedSchemaEntry{checksum: checksum, fields: fields})
+	return fields, nil
+}
@@ -0,0 +1,46 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// SchemaCacheStats contains counters of the use of the schema cache since the process started.
+type SchemaCacheStats struct {
+	// Hits is the number of schemas read from the fields cache directory.
+	Hits uint64 `json:"hits"`
+	// SharedHits is the number of schemas read from shared cache directories.
+	SharedHits uint64 `json:"shared_hits"`
+	// Misses is the number of schemas not found in any cache, or ignored because a refresh was forced.
+	Misses uint64 `json:"misses"`
+	// Downloads is the number of schemas downloaded.
+	Downloads uint64 `json:"downloads"`
+	// DownloadedBytes is the size of the downloaded schemas.
+	DownloadedBytes uint64 `json:"downloaded_bytes"`
+}
+
+// String returns a one-line summary of the counters.
+func (s SchemaCacheStats) String() string {
+	return fmt.Sprintf("hits: %d, shared hits: %d, misses: %d, downloads: %d, downloaded bytes: %d",
+		s.Hits, s.SharedHits, s.Misses, s.Downloads, s.DownloadedBytes)
+}
+
+// schemaCacheCounters are the counters returned by CurrentSchemaCacheStats.
+var schemaCacheCounters struct {
+	hits, sharedHits, misses, downloads, downloadedBytes atomic.Uint64
+}
+
+// CurrentSchemaCacheStats function returns the counters of the use of the schema cache since the process started.
+func CurrentSchemaCacheStats() SchemaCacheStats {
+	return SchemaCacheStats{
+		Hits:            schemaCacheCounters.hits.Load(),
+		SharedHits:      schemaCacheCounters.sharedHits.Load(),
+		Misses:          schemaCacheCounters.misses.Load(),
+		Downloads:       schemaCacheCounters.downloads.Load(),
+		DownloadedBytes: schemaCacheCounters.downloadedBytes.Load(),
+	}
+}
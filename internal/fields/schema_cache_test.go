@@ -0,0 +1,77 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsedSchemaCache(t *testing.T) {
+	cache := newParsedSchemaCache(2)
+
+	var parsed int
+	var mutex sync.Mutex
+	parse := func(content []byte) ([]FieldDefinition, error) {
+		mutex.Lock()
+		parsed++
+		mutex.Unlock()
+		return parseECSFieldsSchema(content)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fields, err := cache.parse("ecs@v8.0.0", []byte(testECSSchema), parse)
+			assert.NoError(t, err)
+			assert.Len(t, fields, 1)
+		}()
+	}
+	wg.Wait()
+	parsedAfterFirstLoad := parsed
+	assert.GreaterOrEqual(t, parsedAfterFirstLoad, 1)
+
+	_, err := cache.parse("ecs@v8.0.0", []byte(testECSSchema), parse)
+	require.NoError(t, err)
+	assert.Equal(t, parsedAfterFirstLoad, parsed, "schema with same content must not be parsed again")
+
+	_, err = cache.parse("ecs@v8.0.0", []byte(testECSSchema+"\n"), parse)
+	require.NoError(t, err)
+	assert.Equal(t, parsedAfterFirstLoad+1, parsed, "schema with different content must be parsed again")
+
+	for i := 1; i <= 2; i++ {
+		_, err = cache.parse(fmt.Sprintf("ecs@v8.%d.0", i), []byte(testECSSchema), parse)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, cache.entries.len())
+	_, found := cache.entries.get("ecs@v8.0.0")
+	assert.False(t, found, "least recently used schema must be evicted")
+}
+
+func TestSchemaLRU(t *testing.T) {
+	cache := newSchemaLRU(2)
+
+	cache.put("ecs@v8.0.0", "a")
+	cache.put("ecs@v8.1.0", "b")
+	_, found := cache.get("ecs@v8.0.0")
+	assert.True(t, found)
+
+	cache.put("ecs@v8.2.0", "c")
+	assert.Equal(t, 2, cache.len())
+	_, found = cache.get("ecs@v8.1.0")
+	assert.False(t, found, "least recently used entry must be evicted")
+
+	cache.put("ecs@v8.0.0", "d")
+	value, found := cache.get("ecs@v8.0.0")
+	require.True(t, found)
+	assert.Equal(t, "d", value)
+	assert.Equal(t, 2, cache.len())
+}
@@ -0,0 +1,77 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver"
+
+	"github.com/elastic/elastic-package/internal/logger"
+)
+
+// ecsCompatibleVersionsRange is the range of ECS versions whose schemas are known to be parsed correctly. Newer
+// versions can introduce changes in the generated schemas that are not supported yet, like new attributes
+// or layouts, and their fields could be silently dropped.
+const ecsCompatibleVersionsRange = ">= 1.0.0, < 9.0.0"
+
+var ecsCompatibleVersions = mustConstraint(ecsCompatibleVersionsRange)
+
+// untestedReferencesWarned contains the moving references already warned about, so the warning is only
+// shown once by process.
+var untestedReferencesWarned sync.Map
+
+func mustConstraint(c string) *semver.Constraints {
+	constraint, err := semver.NewConstraint(c)
+	if err != nil {
+		panic(err)
+	}
+	return constraint
+}
+
+// warnUntestedECSReference warns when the ECS schema is imported from a reference outside of the range of
+// compatible versions, or from a moving reference not named after a version, like main, that can include
+// unreleased changes. Parsing continues in any case.
+func warnUntestedECSReference(reference string) {
+	if !strings.HasPrefix(reference, gitReferencePrefix) {
+		return
+	}
+	gitReference := strings.TrimPrefix(reference, gitReferencePrefix)
+	message, untested := ecsReferenceCompatibility(gitReference)
+	if !untested {
+		return
+	}
+	if _, warned := untestedReferencesWarned.LoadOrStore(gitReference, true); warned {
+		return
+	}
+	logger.Warn(message)
+}
+
+// ecsReferenceCompatibility returns a message describing why the ECS schema of a Git reference hasn't been
+// tested with this version of the tool, if that's the case. Commit SHAs are not checked, as their version is unknown.
+func ecsReferenceCompatibility(gitReference string) (string, bool) {
+	if !ecsVersionReferencePattern.MatchString(gitReference) {
+		if (gitSchemaSource{reference: gitReference}).pinned() {
+			return "", false
+		}
+		return fmt.Sprintf("ECS reference %s is not a released version, its schema may include changes not supported yet by this version of elastic-package (compatible versions: %s)", gitReference, ecsCompatibleVersionsRange), true
+	}
+
+	version, err := semver.NewVersion(gitReference)
+	if err != nil {
+		return "", false
+	}
+	// Pre-releases are checked as their releases.
+	release, err := version.SetPrerelease("")
+	if err != nil {
+		return "", false
+	}
+	if !ecsCompatibleVersions.Check(&release) {
+		return fmt.Sprintf("ECS version %s hasn't been tested with this version of elastic-package, some fields may not be imported correctly (compatible versions: %s)", version, ecsCompatibleVersionsRange), true
+	}
+	return "", false
+}
@@ -0,0 +1,41 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestECSReferenceCompatibility(t *testing.T) {
+	cases := []struct {
+		reference string
+		untested  bool
+	}{
+		{reference: "v8.11.0"},
+		{reference: "8.11"},
+		{reference: "v8.12.0-rc1"},
+		{reference: "v1.12.2"},
+		{reference: "1f2d3c4"},
+		{reference: "v9.0.0", untested: true},
+		{reference: "v9.1.0-beta1", untested: true},
+		{reference: "10.0", untested: true},
+		{reference: "main", untested: true},
+		{reference: "feature-branch", untested: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.reference, func(t *testing.T) {
+			message, untested := ecsReferenceCompatibility(c.reference)
+			assert.Equal(t, c.untested, untested)
+			if c.untested {
+				assert.Contains(t, message, ecsCompatibleVersionsRange)
+			} else {
+				assert.Empty(t, message)
+			}
+		})
+	}
+}
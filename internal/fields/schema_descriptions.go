@@ -0,0 +1,42 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// WithDescriptionOverlay configures the dependency manager to replace the descriptions of imported fields with
+// the ones in the given map, keyed by field path, e.g. to use translated or extended descriptions. Only
+// descriptions are replaced, types and other attributes are imported from the schemas as usual. Descriptions
+// defined in local definitions still take precedence.
+func WithDescriptionOverlay(descriptions map[string]string) DependencyManagerOption {
+	return func(dm *DependencyManager) error {
+		dm.descriptions = make(map[string]string, len(descriptions))
+		for path, description := range descriptions {
+			dm.descriptions[path] = description
+		}
+		return nil
+	}
+}
+
+// ReadDescriptionOverlay reads a file with descriptions of fields, as a YAML map keyed by field path, to be used
+// with WithDescriptionOverlay.
+func ReadDescriptionOverlay(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read description overlay")
+	}
+
+	var descriptions map[string]string
+	err = yaml.Unmarshal(content, &descriptions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't parse description overlay (path: %s)", path)
+	}
+	return descriptions, nil
+}
@@ -0,0 +1,107 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/elastic-package/internal/logger"
+)
+
+// etagExt is the extension of the files storing the ETag of cached schemas, next to them.
+const etagExt = ".etag"
+
+// conditionalSchemaSource is a source that can download schema files only if they don't match an ETag.
+type conditionalSchemaSource interface {
+	schemaSource
+
+	// downloadIfModified downloads the schema file, unless it matches the given ETag.
+	downloadIfModified(ctx context.Context, schemaFile, etag string) (schemaDownload, error)
+}
+
+// revalidateCachedSchema checks if the cached schema of a moving reference is still up to date, using the ETag
+// stored when it was downloaded. Schemas younger than the maximum age of cached schemas are used as cached, without
+// revalidation. Not modified schemas are used as cached, and their timestamp is refreshed. Modified ones are
+// downloaded and cached again. Schemas cached without ETag, or whose revalidation fails, are used as cached, with a
+// warning if they are old.
+func revalidateCachedSchema(ctx context.Context, source schemaSource, reference, schemaFile, cachedSchemaPath string, cached []byte) ([]byte, error) {
+	if age, err := cachedSchemaAge(cachedSchemaPath); err == nil && age <= schemaCacheMaxAge() {
+		logger.Debugf("Cached schema of moving reference %s is recent (age: %s), not revalidating it", reference, age.Round(time.Second))
+		return cached, nil
+	}
+
+	conditionalSource, ok := source.(conditionalSchemaSource)
+	etag := readCachedETag(cachedSchemaPath)
+	if !ok || etag == "" {
+		warnStaleCachedSchema(reference, cachedSchemaPath)
+		return cached, nil
+	}
+
+	logger.Debugf("Revalidating cached schema of moving reference %s (ETag: %s)", reference, etag)
+	downloaded, err := conditionalSource.downloadIfModified(ctx, schemaFile, etag)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		logger.Warnf("cached schema for moving reference %q couldn't be revalidated, using cached schema: %v", reference, err)
+		warnStaleCachedSchema(reference, cachedSchemaPath)
+		return cached, nil
+	}
+	if downloaded.notModified {
+		logger.Debugf("Cached schema not modified: %s", cachedSchemaPath)
+		touchCachedSchema(cachedSchemaPath)
+		return cached, nil
+	}
+
+	logger.Debugf("Cached schema modified, replacing it: %s", cachedSchemaPath)
+	cacheDownloadedSchema(cachedSchemaPath, downloaded.content, downloaded.etag)
+	return downloaded.content, nil
+}
+
+// readCachedETag returns the ETag stored for a cached schema, or an empty string if there is none.
+func readCachedETag(cachedSchemaPath string) string {
+	content, err := os.ReadFile(cachedSchemaPath + etagExt)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Debugf("Can't read ETag of cached schema (path: %s): %v", cachedSchemaPath, err)
+		}
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// writeCachedETag stores the ETag of a cached schema, or removes the stored one if the ETag is empty, so
+// schemas downloaded without ETag are not revalidated with an outdated one.
+func writeCachedETag(cachedSchemaPath, etag string) error {
+	etagPath := cachedSchemaPath + etagExt
+	if etag == "" {
+		err := os.Remove(etagPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return errors.Wrapf(err, "can't remove ETag of cached schema (path: %s)", etagPath)
+		}
+		return nil
+	}
+	err := writeCachedSchemaFile(etagPath, []byte(etag), 0644)
+	if err != nil {
+		return errors.Wrapf(err, "can't write ETag of cached schema (path: %s)", etagPath)
+	}
+	return nil
+}
+
+// touchCachedSchema refreshes the timestamps of a cached schema and its ETag, as done when the schema is downloaded.
+func touchCachedSchema(cachedSchemaPath string) {
+	now := time.Now()
+	for _, path := range []string{cachedSchemaPath + compressedSchemaExt, cachedSchemaPath + etagExt} {
+		err := os.Chtimes(path, now, now)
+		if err != nil {
+			logger.Debugf("Can't refresh timestamp of cached schema (path: %s): %v", path, err)
+		}
+	}
+}
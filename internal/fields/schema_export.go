@@ -0,0 +1,79 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ExportedSchema is the snapshot of a schema loaded by a dependency manager.
+type ExportedSchema struct {
+	// Name is the name of the schema, as used in `external` attributes.
+	Name string `yaml:"name"`
+	// Reference is the reference the schema was loaded from, if known.
+	Reference string `yaml:"reference,omitempty"`
+	// FieldCount is the number of leaf fields defined in the schema.
+	FieldCount int `yaml:"field_count"`
+	// Fields are the parsed definitions of the schema, in the format of fields files.
+	Fields []FieldDefinition `yaml:"fields"`
+}
+
+// ExportSchemas method writes a YAML snapshot of the schemas loaded by the dependency manager, sorted by name.
+// The snapshot contains the definitions as parsed, so builds can be reproduced later even if the upstream schemas
+// change. Schemas of data streams overriding the dependencies are not included.
+func (dm *DependencyManager) ExportSchemas(w io.Writer) error {
+	if dm == nil {
+		return errors.New("dependency manager is not available")
+	}
+	err := dm.loadLazySchemas()
+	if err != nil {
+		return err
+	}
+
+	dm.schemaMutex.RLock()
+	names := make([]string, 0, len(dm.schema))
+	for name := range dm.schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var schemas []ExportedSchema
+	for _, name := range names {
+		defs := dm.schema[name]
+		idx := dm.indexes[name]
+		if idx == nil {
+			// Schema without definitions, like ECS when no reference is configured.
+			continue
+		}
+		schemas = append(schemas, ExportedSchema{
+			Name:       name,
+			Reference:  dm.schemaReference(name),
+			FieldCount: len(idx.leafPaths()),
+			Fields:     defs,
+		})
+	}
+	dm.schemaMutex.RUnlock()
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	err = enc.Encode(schemas)
+	if err != nil {
+		return errors.Wrap(err, "can't encode schemas")
+	}
+	return enc.Close()
+}
+
+// schemaReference returns the reference a schema was loaded from, or an empty string for schemas
+// not loaded from the dependencies.
+func (dm *DependencyManager) schemaReference(name string) string {
+	if name == ecsSchemaName {
+		return dm.deps.ECS.Reference
+	}
+	return ""
+}
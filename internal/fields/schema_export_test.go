@@ -0,0 +1,57 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/elastic/elastic-package/internal/packages/buildmanifest"
+)
+
+func TestDependencyManagerExportSchemas(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ecs/v8.0.0/ecs_nested.yml" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, testECSSchema)
+	}))
+	defer server.Close()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+	dm, err := CreateFieldDependencyManager(context.Background(), deps, WithLazySchemaLoading())
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = dm.ExportSchemas(&buf)
+	require.NoError(t, err)
+
+	var exported []ExportedSchema
+	err = yaml.Unmarshal(buf.Bytes(), &exported)
+	require.NoError(t, err)
+	require.Len(t, exported, 1)
+	assert.Equal(t, "ecs", exported[0].Name)
+	assert.Equal(t, "git@v8.0.0", exported[0].Reference)
+	assert.Equal(t, 1, exported[0].FieldCount)
+
+	snapshot, err := createDependencyManagerWithSchemas(map[string][]FieldDefinition{"ecs": exported[0].Fields})
+	require.NoError(t, err)
+	imported, err := snapshot.ImportField("ecs", "container.id")
+	require.NoError(t, err)
+	assert.Equal(t, "keyword", imported.Type)
+	assert.Equal(t, "Unique container id.", imported.Description)
+}
@@ -0,0 +1,56 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/elastic/elastic-package/internal/environment"
+	"github.com/elastic/elastic-package/internal/version"
+)
+
+// schemaUserAgentEnv is the name of the environment variable used to override the User-Agent header of the
+// requests to download schemas.
+var schemaUserAgentEnv = environment.WithElasticPackagePrefix("SCHEMA_USER_AGENT")
+
+// maxSchemaRedirects is the maximum number of redirects followed when downloading schemas.
+const maxSchemaRedirects = 5
+
+// schemaHTTPClient returns the HTTP client used to download schemas, based on the default one, with the redirects
+// it follows limited.
+func schemaHTTPClient() *http.Client {
+	client := *http.DefaultClient
+	client.CheckRedirect = checkSchemaRedirect
+	return &client
+}
+
+// checkSchemaRedirect stops following redirects after maxSchemaRedirects, so misconfigured mirrors
+// redirecting in loops fail with the chain of redirects, instead of being silently followed.
+func checkSchemaRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) <= maxSchemaRedirects {
+		return nil
+	}
+	chain := make([]string, 0, len(via)+1)
+	for _, r := range via {
+		chain = append(chain, r.URL.String())
+	}
+	chain = append(chain, req.URL.String())
+	return fmt.Errorf("stopped after %d redirects: %s", maxSchemaRedirects, strings.Join(chain, " -> "))
+}
+
+// schemaUserAgent returns the User-Agent header of the requests to download schemas, that identifies the tool
+// and its version, e.g. for mirrors logging or rate-limiting requests by user agent.
+func schemaUserAgent() string {
+	if userAgent := os.Getenv(schemaUserAgentEnv); userAgent != "" {
+		return userAgent
+	}
+	if version.Tag == "" {
+		return "elastic-package"
+	}
+	return "elastic-package/" + strings.TrimPrefix(version.Tag, "v")
+}
@@ -0,0 +1,113 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"sort"
+	"strings"
+)
+
+// schemaIndex indexes the field definitions of a schema by their full path, so they
+// can be found without traversing the whole tree of definitions.
+type schemaIndex struct {
+	// fields contains definitions that can only match their own path.
+	fields map[string]indexedField
+	// patterns contains, in schema order, definitions that can match other paths,
+	// like fields with wildcards in their names or with implicit subfields.
+	patterns []indexedField
+}
+
+type indexedField struct {
+	key   string
+	order int
+	leaf  bool
+	def   FieldDefinition
+}
+
+// newSchemaIndex builds the index for the given definitions.
+func newSchemaIndex(defs []FieldDefinition) *schemaIndex {
+	idx := &schemaIndex{
+		fields: make(map[string]indexedField),
+	}
+	var order int
+	idx.add("", defs, &order)
+	return idx
+}
+
+func (idx *schemaIndex) add(root string, defs []FieldDefinition, order *int) {
+	for _, def := range defs {
+		key := strings.TrimLeft(root+"."+def.Name, ".")
+		children := def.Fields
+
+		field := indexedField{
+			key:   key,
+			order: *order,
+			leaf:  len(children) == 0 && def.Type != "group",
+			def:   def,
+		}
+		*order++
+
+		if isPatternDefinition(key, def) {
+			idx.patterns = append(idx.patterns, field)
+		} else if _, found := idx.fields[key]; !found {
+			idx.fields[key] = field
+		}
+
+		if len(children) > 0 {
+			idx.add(key, children, order)
+		}
+	}
+}
+
+// isPatternDefinition checks if the definition can match paths different to its own
+// key, as done by compareKeys.
+func isPatternDefinition(key string, def FieldDefinition) bool {
+	if strings.Contains(key, "*") || def.External != "" {
+		return true
+	}
+
+	fieldType := def.Type
+	if def.Type == "object" && def.ObjectType != "" {
+		fieldType = def.ObjectType
+	}
+	return fieldType == "geo_point" || fieldType == "histogram"
+}
+
+// find returns the same definition FindElementDefinition would return for the indexed
+// definitions, or nil if there is none.
+func (idx *schemaIndex) find(searchedKey string) *FieldDefinition {
+	field, found := idx.fields[searchedKey]
+	for i := range idx.patterns {
+		pattern := &idx.patterns[i]
+		if found && pattern.order > field.order {
+			break
+		}
+		if compareKeys(pattern.key, pattern.def, searchedKey) {
+			def := pattern.def
+			return &def
+		}
+	}
+	if !found {
+		return nil
+	}
+	return &field.def
+}
+
+// leafPaths returns the sorted paths of all indexed leaf fields.
+func (idx *schemaIndex) leafPaths() []string {
+	var paths []string
+	for _, field := range idx.fields {
+		if field.leaf {
+			paths = append(paths, field.key)
+		}
+	}
+	for _, field := range idx.patterns {
+		if field.leaf {
+			paths = append(paths, field.key)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,156 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestSchemaIndexFind(t *testing.T) {
+	schema := []FieldDefinition{
+		{
+			Name: "container.id",
+			Type: "keyword",
+		},
+		{
+			Name: "labels.*",
+			Type: "keyword",
+		},
+		{
+			Name: "labels.foo",
+			Type: "long",
+		},
+		{
+			Name: "source",
+			Type: "group",
+			Fields: []FieldDefinition{
+				{
+					Name: "geo.location",
+					Type: "geo_point",
+				},
+				{
+					Name: "ip",
+					Type: "ip",
+				},
+			},
+		},
+		{
+			Name: "container.id",
+			Type: "wildcard",
+		},
+		{
+			Name:       "metrics",
+			Type:       "object",
+			ObjectType: "histogram",
+		},
+	}
+	idx := newSchemaIndex(schema)
+
+	keys := []string{
+		"container.id",
+		"labels.foo",
+		"labels.bar",
+		"labels",
+		"source",
+		"source.ip",
+		"source.geo.location",
+		"source.geo.location.lat",
+		"source.geo.location.alt",
+		"metrics.values",
+		"metrics.sum",
+		"unknown",
+	}
+	for _, key := range keys {
+		t.Run(key, func(t *testing.T) {
+			assert.Equal(t, FindElementDefinition(key, schema), idx.find(key))
+		})
+	}
+
+	assert.Equal(t, []string{"container.id", "labels.*", "labels.foo", "metrics", "source.geo.location", "source.ip"}, idx.leafPaths())
+}
+
+// generateLargeSchema generates a schema with the given number of groups and fields per group.
+func generateLargeSchema(groups, fields int) []FieldDefinition {
+	schema := make([]FieldDefinition, groups)
+	for i := range schema {
+		schema[i] = FieldDefinition{
+			Name:        fmt.Sprintf("group%d", i),
+			Description: fmt.Sprintf("Description of group %d.", i),
+			Type:        "group",
+		}
+		for j := 0; j < fields; j++ {
+			schema[i].Fields = append(schema[i].Fields, FieldDefinition{
+				Name:        fmt.Sprintf("field%d", j),
+				Description: fmt.Sprintf("Description of field %d in group %d.", j, i),
+				Type:        "keyword",
+				MultiFields: []FieldDefinition{
+					{Name: "text", Type: "match_only_text"},
+				},
+			})
+		}
+	}
+	return schema
+}
+
+func BenchmarkFindElementDefinition(b *testing.B) {
+	schema := benchmarkECSSchema(b)
+	paths := listFieldPaths("", schema, nil)
+
+	b.Run("linear", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, path := range paths {
+				FindElementDefinition(path, schema)
+			}
+		}
+	})
+	b.Run("indexed", func(b *testing.B) {
+		idx := newSchemaIndex(schema)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, path := range paths {
+				idx.find(path)
+			}
+		}
+	})
+}
+
+// benchmarkECSSchema returns the schema used in benchmarks. The real ECS schema can be used by setting
+// the ECS_NESTED_SCHEMA_FILE environment variable to the path of an ecs_nested.yml file, otherwise a
+// schema of similar size is generated.
+func benchmarkECSSchema(b *testing.B) []FieldDefinition {
+	path := os.Getenv("ECS_NESTED_SCHEMA_FILE")
+	if path == "" {
+		return generateLargeSchema(100, 20)
+	}
+
+	schema, err := parseECSFieldsSchema(benchmarkECSSchemaContent(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return schema
+}
+
+// benchmarkECSSchemaContent returns the content of the schema used in benchmarks, see benchmarkECSSchema.
+func benchmarkECSSchemaContent(b *testing.B) []byte {
+	path := os.Getenv("ECS_NESTED_SCHEMA_FILE")
+	if path == "" {
+		content, err := yaml.Marshal(generateLargeSchema(100, 20))
+		if err != nil {
+			b.Fatal(err)
+		}
+		return content
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return content
+}
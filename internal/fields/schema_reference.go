@@ -0,0 +1,67 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// referenceParser parses a reference into the source its schemas are loaded from.
+type referenceParser func(reference string) (schemaSource, error)
+
+// referenceParsers are the parsers of the supported references, by prefix.
+var referenceParsers = map[string]referenceParser{
+	gitReferencePrefix: parseGitReference,
+}
+
+// newSchemaSource returns the source of schemas for the given reference, parsed by the parser registered for its
+// prefix. If more than one prefix matches, the longest one is used.
+func newSchemaSource(reference string) (schemaSource, error) {
+	var prefix string
+	for p := range referenceParsers {
+		if strings.HasPrefix(reference, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+	if prefix == "" {
+		return nil, fmt.Errorf(`unsupported reference ("%s" prefix expected)`, gitReferencePrefix)
+	}
+	return referenceParsers[prefix](reference)
+}
+
+// normalizeReference returns a normalized form of the given reference, the same for equivalent references.
+// Invalid references are returned as is.
+func normalizeReference(reference string) string {
+	source, err := newSchemaSource(reference)
+	if err != nil {
+		return reference
+	}
+	return source.cacheKey()
+}
+
+func parseGitReference(reference string) (schemaSource, error) {
+	gitReference, err := asGitReference(reference)
+	if err != nil {
+		return nil, err
+	}
+	return gitSchemaSource{reference: gitReference}, nil
+}
+
+func asGitReference(reference string) (string, error) {
+	if !strings.HasPrefix(reference, gitReferencePrefix) {
+		return "", errors.New(`invalid Git reference ("git@" prefix expected)`)
+	}
+	gitReference := reference[len(gitReferencePrefix):]
+
+	// The reference is used as part of the path of cached files, ensure that it can't point to other locations.
+	if gitReference == "" || gitReference == "." || gitReference == ".." || strings.ContainsAny(gitReference, `/\:`) || filepath.IsAbs(gitReference) {
+		return "", fmt.Errorf("invalid Git reference (tag, branch or commit SHA expected, without path separators): %s", gitReference)
+	}
+	return gitReference, nil
+}
@@ -0,0 +1,55 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSchemaSource(t *testing.T) {
+	cases := []struct {
+		title     string
+		reference string
+		expected  schemaSource
+		err       string
+	}{
+		{
+			title:     "git reference",
+			reference: "git@v8.11.0",
+			expected:  gitSchemaSource{reference: "v8.11.0"},
+		},
+		{
+			title:     "invalid git reference",
+			reference: "git@../v8.11.0",
+			err:       "invalid Git reference",
+		},
+		{
+			title:     "unknown prefix",
+			reference: "http://schemas.example.com/ecs",
+			err:       `unsupported reference ("git@" prefix expected)`,
+		},
+		{
+			title:     "no prefix",
+			reference: "v8.11.0",
+			err:       `"git@" prefix expected`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			source, err := newSchemaSource(c.reference)
+			if c.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), c.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.expected, source)
+		})
+	}
+}
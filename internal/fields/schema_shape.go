@@ -0,0 +1,90 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+
+	"github.com/elastic/elastic-package/internal/common"
+)
+
+// parentFieldTypes are the types of definitions that can have child fields.
+var parentFieldTypes = []string{"", "group", "object", "nested"}
+
+// checkSchemaShape checks that the content of a schema is a list of field definitions, or a map of field
+// groups as in ECS schemas, so mistakes are reported when the schema is loaded and not when fields are
+// not found later.
+func checkSchemaShape(content []byte) error {
+	var doc yaml.Node
+	err := yaml.Unmarshal(content, &doc)
+	if err != nil {
+		return errors.Wrap(err, "unmarshalling schema failed")
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	root := doc.Content[0]
+	switch root.Kind {
+	case yaml.SequenceNode:
+		for i, entry := range root.Content {
+			if entry.Kind != yaml.MappingNode {
+				return fmt.Errorf("entry #%d of schema (line %d) must be a field definition, found %s", i+1, entry.Line, describeNode(entry))
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			key, value := root.Content[i], root.Content[i+1]
+			if value.Kind != yaml.MappingNode {
+				return fmt.Errorf("entry %q of schema (line %d) must be a field group, found %s (single field definitions must be placed in a list)", key.Value, key.Line, describeNode(value))
+			}
+		}
+	default:
+		return fmt.Errorf("schema must be a list of field definitions or a map of field groups, found %s", describeNode(root))
+	}
+	return nil
+}
+
+// validateSchemaDefinitions checks the structural invariants of parsed field definitions.
+func validateSchemaDefinitions(root string, defs []FieldDefinition) error {
+	for i, def := range defs {
+		if def.Name == "" {
+			return fmt.Errorf("field definition #%d%s has no name", i+1, enclosingGroups(root))
+		}
+		path := def.Name
+		if root != "" {
+			path = root + "." + def.Name
+		}
+		if len(def.Fields) == 0 {
+			continue
+		}
+		if !common.StringSliceContains(parentFieldTypes, def.Type) {
+			return fmt.Errorf("field %q has child fields, but its type is %q (group expected)", path, def.Type)
+		}
+		err := validateSchemaDefinitions(path, def.Fields)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func describeNode(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return "list"
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.ScalarNode:
+		return fmt.Sprintf("scalar value %q", node.Value)
+	case yaml.AliasNode:
+		return "alias"
+	default:
+		return "empty value"
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseECSFieldsSchemaShape(t *testing.T) {
+	cases := []struct {
+		title    string
+		content  string
+		expected string
+	}{
+		{
+			title:   "valid list",
+			content: "- name: service.tier\n  type: keyword\n",
+		},
+		{
+			title:   "valid map",
+			content: testECSSchema,
+		},
+		{
+			title:    "scalar",
+			content:  "service.tier\n",
+			expected: `schema must be a list of field definitions or a map of field groups, found scalar value "service.tier"`,
+		},
+		{
+			title:    "single definition",
+			content:  "name: service.tier\ntype: keyword\n",
+			expected: `entry "name" of schema (line 1) must be a field group, found scalar value "service.tier" (single field definitions must be placed in a list)`,
+		},
+		{
+			title:    "scalar in list",
+			content:  "- name: service.tier\n  type: keyword\n- service.name\n",
+			expected: `entry #2 of schema (line 3) must be a field definition, found scalar value "service.name"`,
+		},
+		{
+			title:    "missing name",
+			content:  "- name: service\n  type: group\n  fields:\n    - type: keyword\n",
+			expected: "field definition #1 under service group has no name",
+		},
+		{
+			title:    "children of leaf field",
+			content:  "- name: service\n  type: keyword\n  fields:\n    - name: tier\n      type: keyword\n",
+			expected: `field "service" has child fields, but its type is "keyword" (group expected)`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			fields, err := parseECSFieldsSchema([]byte(c.content))
+			if c.expected == "" {
+				require.NoError(t, err)
+				assert.NotEmpty(t, fields)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), c.expected)
+			}
+		})
+	}
+}
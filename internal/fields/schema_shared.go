@@ -0,0 +1,71 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/elastic/elastic-package/internal/logger"
+	"github.com/elastic/elastic-package/internal/packages/buildmanifest"
+)
+
+const sharedSchemasLimit = 8
+
+// sharedSchemas keeps the schemas loaded and indexed by dependency managers created with WithSharedSchemas,
+// so managers of different packages with the same dependencies don't read nor index them again.
+var sharedSchemas = newSchemaLRU(sharedSchemasLimit)
+
+// WithSharedSchemas configures the dependency manager to share the loaded schemas with other dependency managers
+// of this process created with this option, e.g. when building many packages depending on the same ECS reference.
+// Schemas are shared by reference, so they are read, parsed and indexed only once, and schemas of moving
+// references are not revalidated in the same process. Only the most recently used schemas are kept.
+func WithSharedSchemas() DependencyManagerOption {
+	return func(dm *DependencyManager) error {
+		dm.sharedSchemas = true
+		return nil
+	}
+}
+
+// loadedSchema contains the definitions of a loaded schema, and its index. Both are nil for dependencies
+// not defined.
+type loadedSchema struct {
+	defs  []FieldDefinition
+	index *schemaIndex
+}
+
+// sharedSchemaKey returns the key of the schema of an ECS dependency in the shared schemas. References are
+// normalized, so equivalent ones share the same schema. Schemas loaded without extra attributes don't keep them,
+// so they are shared separately.
+func sharedSchemaKey(dep buildmanifest.ECSDependency, keepExtra bool) string {
+	return ecsSchemaName + "@" + normalizeReference(dep.Reference) + "/" + dep.SchemaFile +
+		"?experimental=" + strconv.FormatBool(dep.Experimental) +
+		"&extra=" + strconv.FormatBool(keepExtra)
+}
+
+// loadECSSchema loads and indexes the schema of an ECS dependency, or gets it from the shared schemas if the
+// dependency manager shares its schemas.
+func (dm *DependencyManager) loadECSSchema(ctx context.Context, dep buildmanifest.ECSDependency) (loadedSchema, error) {
+	if !dm.sharedSchemas {
+		defs, err := loadECSFieldsSchema(ctx, dep, dm.keepsExtraAttributes())
+		if err != nil {
+			return loadedSchema{}, err
+		}
+		return dm.indexSchema(defs), nil
+	}
+
+	key := sharedSchemaKey(dep, dm.keepsExtraAttributes())
+	if schema, found := sharedSchemas.get(key); found {
+		logger.Debugf("Shared schema hit: %s", key)
+		return schema.(loadedSchema), nil
+	}
+	defs, err := loadECSFieldsSchema(ctx, dep, dm.keepsExtraAttributes())
+	if err != nil {
+		return loadedSchema{}, err
+	}
+	schema := dm.indexSchema(defs)
+	sharedSchemas.put(key, schema)
+	return schema, nil
+}
@@ -0,0 +1,100 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License;
+// you may not use this file except in compliance with the Elastic License.
+
+package fields
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-package/internal/common"
+	"github.com/elastic/elastic-package/internal/packages/buildmanifest"
+)
+
+func TestSharedSchemaKey(t *testing.T) {
+	key := func(reference string) string {
+		return sharedSchemaKey(buildmanifest.ECSDependency{Reference: reference}, false)
+	}
+	assert.NotEqual(t, key("git@v8.11.0"), key("git@v8.12.0"))
+	assert.NotEqual(t, key("git@v8.11.0"), sharedSchemaKey(buildmanifest.ECSDependency{Reference: "git@v8.11.0"}, true))
+}
+
+func TestCreateFieldDependencyManagerWithSharedSchemas(t *testing.T) {
+	defaultSharedSchemas := sharedSchemas
+	sharedSchemas = newSchemaLRU(sharedSchemasLimit)
+	defer func() { sharedSchemas = defaultSharedSchemas }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testECSSchema)
+	}))
+	defer server.Close()
+
+	t.Setenv("ELASTIC_PACKAGE_DATA_HOME", t.TempDir())
+	t.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+	first, err := CreateFieldDependencyManager(context.Background(), deps, WithSharedSchemas())
+	require.NoError(t, err)
+	second, err := CreateFieldDependencyManager(context.Background(), deps, WithSharedSchemas(), WithLazySchemaLoading())
+	require.NoError(t, err)
+	notShared, err := CreateFieldDependencyManager(context.Background(), deps)
+	require.NoError(t, err)
+
+	for _, dm := range []*DependencyManager{first, second, notShared} {
+		imported, err := dm.ImportField("ecs", "container.id")
+		require.NoError(t, err)
+		assert.Equal(t, "keyword", imported.Type)
+	}
+	assert.Same(t, first.indexes["ecs"], second.indexes["ecs"])
+	assert.NotSame(t, first.indexes["ecs"], notShared.indexes["ecs"])
+}
+
+func BenchmarkSharedSchemas(b *testing.B) {
+	const packages = 20
+
+	content := benchmarkECSSchemaContent(b)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	b.Setenv("ELASTIC_PACKAGE_DATA_HOME", b.TempDir())
+	b.Setenv(ecsSchemaURLEnv, server.URL+"/ecs/%s/%s")
+
+	defaultSharedSchemas := sharedSchemas
+	defer func() { sharedSchemas = defaultSharedSchemas }()
+
+	deps := buildmanifest.Dependencies{ECS: buildmanifest.ECSDependency{Reference: "git@v8.0.0"}}
+	defs := []common.MapStr{{"name": "message", "type": "text"}}
+	for _, c := range []struct {
+		name string
+		opts []DependencyManagerOption
+	}{
+		{"not shared", nil},
+		{"shared", []DependencyManagerOption{WithSharedSchemas()}},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				// Every iteration is a run building multiple packages with the same dependencies.
+				sharedSchemas = newSchemaLRU(sharedSchemasLimit)
+				for p := 0; p < packages; p++ {
+					dm, err := CreateFieldDependencyManager(context.Background(), deps, c.opts...)
+					if err != nil {
+						b.Fatal(err)
+					}
+					_, _, err = dm.InjectFields(defs)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ package fields
 
 import (
 	"bufio"
+	"context"
 	_ "embed"
 	"encoding/json"
 	"fmt"
@@ -151,11 +152,19 @@ func CreateValidatorForDirectory(fieldsParentDir string, opts ...ValidatorOption
 		return v, nil
 	}
 
-	fdm, err := CreateFieldDependencyManager(bm.Dependencies)
+	fdmOpts := []DependencyManagerOption{WithLazySchemaLoading()}
+	if overrides := bm.Dependencies.ECS.LocalOverrides; overrides != "" {
+		fdmOpts = append(fdmOpts, WithLocalOverrides(filepath.Join(packageRoot, overrides)))
+	}
+	fdm, err := CreateFieldDependencyManager(context.Background(), bm.Dependencies, fdmOpts...)
 	if err != nil {
 		return nil, errors.Wrap(err, "can't create field dependency manager")
 	}
 	v.FieldDependencyManager = fdm
+	if filepath.Clean(fieldsParentDir) != filepath.Clean(packageRoot) {
+		// Data streams can override the ECS reference of the package.
+		v.FieldDependencyManager = fdm.ForDataStream(filepath.Base(fieldsParentDir))
+	}
 	return v, nil
 }
 
@@ -513,7 +522,7 @@ func (v *Validator) parseSingleElementValue(key string, definition FieldDefiniti
 		if err := ensureConstantKeywordValueMatches(key, valStr, definition.Value); err != nil {
 			return err
 		}
-		if err := ensurePatternMatches(key, valStr, definition.Pattern); err != nil {
+		if err := ensurePatternMatches(key, valStr, definition.AllPatterns()); err != nil {
 			return err
 		}
 		if err := ensureAllowedValues(key, valStr, definition); err != nil {
@@ -527,7 +536,7 @@ func (v *Validator) parseSingleElementValue(key string, definition FieldDefiniti
 			return invalidTypeError()
 		}
 
-		if err := ensurePatternMatches(key, valStr, definition.Pattern); err != nil {
+		if err := ensurePatternMatches(key, valStr, definition.AllPatterns()); err != nil {
 			return err
 		}
 		if err := ensureAllowedValues(key, valStr, definition); err != nil {
@@ -539,12 +548,12 @@ func (v *Validator) parseSingleElementValue(key string, definition FieldDefiniti
 	case "date":
 		switch val := val.(type) {
 		case string:
-			if err := ensurePatternMatches(key, val, definition.Pattern); err != nil {
+			if err := ensurePatternMatches(key, val, definition.AllPatterns()); err != nil {
 				return err
 			}
 		case float64:
 			// date as seconds or milliseconds since epoch
-			if definition.Pattern != "" {
+			if len(definition.AllPatterns()) > 0 {
 				return fmt.Errorf("numeric date in field %q, but pattern defined", key)
 			}
 		default:
@@ -559,7 +568,7 @@ func (v *Validator) parseSingleElementValue(key string, definition FieldDefiniti
 			return invalidTypeError()
 		}
 
-		if err := ensurePatternMatches(key, valStr, definition.Pattern); err != nil {
+		if err := ensurePatternMatches(key, valStr, definition.AllPatterns()); err != nil {
 			return err
 		}
 
@@ -636,19 +645,24 @@ func forEachElementValue(key string, definition FieldDefinition, val interface{}
 }
 
 // ensurePatternMatches validates the document's field value matches the field
-// definitions regular expression pattern.
-func ensurePatternMatches(key, value, pattern string) error {
-	if pattern == "" {
+// definitions regular expression pattern, or any of them if there are many.
+func ensurePatternMatches(key, value string, patterns []string) error {
+	if len(patterns) == 0 {
 		return nil
 	}
-	valid, err := regexp.MatchString(pattern, value)
-	if err != nil {
-		return errors.Wrap(err, "invalid pattern")
+	for _, pattern := range patterns {
+		valid, err := regexp.MatchString(pattern, value)
+		if err != nil {
+			return errors.Wrap(err, "invalid pattern")
+		}
+		if valid {
+			return nil
+		}
 	}
-	if !valid {
-		return fmt.Errorf("field %q's value, %s, does not match the expected pattern: %s", key, value, pattern)
+	if len(patterns) == 1 {
+		return fmt.Errorf("field %q's value, %s, does not match the expected pattern: %s", key, value, patterns[0])
 	}
-	return nil
+	return fmt.Errorf("field %q's value, %s, does not match any of the expected patterns: %s", key, value, strings.Join(patterns, ", "))
 }
 
 // ensureConstantKeywordValueMatches validates the document's field value
@@ -301,6 +301,23 @@ func Test_parseElementValue(t *testing.T) {
 			},
 			fail: true,
 		},
+		{
+			key:   "keyword with patterns",
+			value: "12",
+			definition: FieldDefinition{
+				Type:     "keyword",
+				Patterns: []string{`^[a-z]+$`, `^[0-9]+$`},
+			},
+		},
+		{
+			key:   "keyword fails patterns",
+			value: "some value",
+			definition: FieldDefinition{
+				Type:     "keyword",
+				Patterns: []string{`^[a-z]+$`, `^[0-9]+$`},
+			},
+			fail: true,
+		},
 		// keyword and constant_keyword (other)
 		{
 			key:   "bad type for keyword",
@@ -25,7 +25,59 @@ type Dependencies struct {
 
 // ECSDependency defines a dependency on ECS fields.
 type ECSDependency struct {
+	// Reference is the reference of the ECS schema to import fields from, e.g. "git@v8.11.0".
 	Reference string `config:"reference"`
+	// SchemaFile is the name of the generated ECS file to import fields from, "ecs_nested.yml" by default.
+	SchemaFile string `config:"schema_file"`
+	// AllowBeta allows to import fields in beta, otherwise importing them fails.
+	AllowBeta bool `config:"allow_beta"`
+	// Experimental selects the experimental schema generated in the ECS repository, with fields in development.
+	Experimental bool `config:"experimental"`
+	// LocalOverrides is the path, relative to the package root, of a fields file with local
+	// definitions that take precedence over the ones of ECS.
+	LocalOverrides string `config:"local_overrides"`
+	// Descriptions is the path, relative to the package root, of a file with descriptions overlaid
+	// on imported fields, keyed by field path.
+	Descriptions string `config:"descriptions"`
+	// StrictTypes makes the build fail when imported fields are declared with types incompatible with
+	// the imported ones, instead of enforcing the imported types.
+	StrictTypes bool `config:"strict_types"`
+	// StrictDuplicates makes the build fail when the same field is imported more than once in a fields
+	// file, instead of merging the definitions.
+	StrictDuplicates bool `config:"strict_duplicates"`
+	// StrictTypeChanges makes the build fail when imported fields have a different type than in the
+	// previous build of the package, instead of warning about it.
+	StrictTypeChanges bool `config:"strict_type_changes"`
+	// ImportMode determines the attributes of imported fields included in built fields files: "build" omits
+	// descriptions and examples, "docs" includes examples too. By default, descriptions are included.
+	ImportMode string `config:"import_mode"`
+	// NameMetadata preserves the flat_name and dashed_name attributes of imported fields.
+	NameMetadata bool `config:"name_metadata"`
+	// DataStreams contains the references used by specific data streams instead of Reference, keyed by
+	// data stream name. Other settings of the dependency apply to all data streams.
+	DataStreams map[string]string `config:"data_streams"`
+	// Fields contains the settings applied when importing specific fields.
+	Fields []FieldSettings `config:"fields"`
+}
+
+// FieldSettings defines how an external field is imported.
+type FieldSettings struct {
+	// Name is the full path of the field, as declared in fields files.
+	Name string `config:"name"`
+	// RemoveMultiFields contains the names of the imported multi-fields to remove.
+	RemoveMultiFields []string `config:"remove_multi_fields"`
+	// KeepMultiFields set to false removes all the imported multi-fields. They are kept if not set.
+	KeepMultiFields *bool `config:"keep_multi_fields"`
+	// IncludeFields contains the names of the child fields to import, for imported groups.
+	IncludeFields []string `config:"include_fields"`
+	// MaxDepth limits the depth of the fields imported by wildcard imports, in path segments below the prefix.
+	MaxDepth int `config:"max_depth"`
+	// KeepImportedType enforces the imported type, also when the field declares a compatible type.
+	KeepImportedType bool `config:"keep_imported_type"`
+	// NormalizeAdd contains normalizations added to the ones of the imported field, instead of replacing them.
+	NormalizeAdd []string `config:"normalize_add"`
+	// ExpectedValues contains the values the field is expected to have, that must be allowed by the imported field.
+	ExpectedValues []string `config:"expected_values"`
 }
 
 // HasDependencies function checks if there are any dependencies defined.